@@ -0,0 +1,322 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "time"
+import "bytes"
+import "github.com/BurntSushi/toml"
+
+// chunks split a long order into smaller frame ranges
+// so that several machines can work on it at once.
+// each chunk keeps its own state and lock file in
+// the order's chunk directory, because the order's
+// manifest can't safely be shared between writers
+type Chunk struct {
+	index int
+	lock  *Lock
+
+	Start_Frame  int  `toml:"start_frame"`
+	End_Frame    int  `toml:"end_frame"`
+	Last_Frame   int  `toml:"last_frame"`
+	Has_Progress bool `toml:"has_progress"`
+	Complete     bool `toml:"complete"`
+
+	Attempts      uint          `toml:"attempts"`
+	Error_Kind    Blender_Error `toml:"error_kind"`
+	Error_Message string        `toml:"error_message"`
+	Exit_Code     int           `toml:"exit_code"`
+	Error_Frame   *int          `toml:"error_frame,omitempty"`
+}
+
+func (chunk *Chunk) resume_frame(step uint) int {
+	return resume_from(chunk.Start_Frame, chunk.Last_Frame, chunk.Has_Progress, step)
+}
+
+func (chunk *Chunk) failed() bool {
+	return chunk.Error_Kind != ALL_GOOD
+}
+
+// the order's error is kept in memory for retries, hooks
+// and notifications, but the chunk keeps its own copy
+func (chunk *Chunk) copy_error(order *Order) {
+	chunk.Error_Kind    = order.Error_Kind
+	chunk.Error_Message = order.Error_Message
+	chunk.Exit_Code     = order.Exit_Code
+	chunk.Error_Frame   = order.Error_Frame
+}
+
+// whoever is rendering a chunk writes its state to the
+// chunk's own file, never to the order's manifest, which
+// any number of machines may be working from at once
+func save_run_state(config *Config, order *Order, chunk *Chunk) bool {
+	if chunk == nil {
+		return update_order(config.project_dir, order.Name, func(current *Order) {
+			current.copy_run_state(order)
+		})
+	}
+
+	chunk.copy_error(order)
+	return save_chunk(config.project_dir, order, chunk)
+}
+
+// a chunked order's manifest is only written when the order
+// is created, changed or completed, so its status and errors
+// in between are pieced together from its chunks instead
+func (order *Order) apply_chunk_state(project_dir string) {
+	if order.Chunk_Size == 0 || order.Status == STATUS_COMPLETE {
+		return
+	}
+
+	order.Status   = STATUS_PENDING
+	order.Attempts = 0
+	order.clear_error()
+
+	for _, chunk := range load_chunks(project_dir, order) {
+		order.Attempts += chunk.Attempts
+
+		if chunk.failed() {
+			if order.Status != STATUS_FAILED {
+				order.set_failed(chunk.Error_Kind, chunk.Error_Message, chunk.Exit_Code, chunk.Error_Frame)
+			}
+			continue
+		}
+
+		if order.Status == STATUS_PENDING && (chunk.Complete || chunk.Has_Progress || chunk.lock != nil) {
+			order.Status = STATUS_RENDERING
+		}
+	}
+}
+
+// divides the order's frame range into spans of
+// Chunk_Size rendered frames, respecting the step
+func make_chunks(order *Order) []*Chunk {
+	if order.Chunk_Size == 0 || order.End_Frame < order.Start_Frame {
+		return nil
+	}
+
+	step := order.Frame_Step
+	if step == 0 {
+		step = 1
+	}
+
+	// frame lists are divided by count instead,
+	// each chunk spanning the frames it holds
+	if order.is_frame_list() {
+		frames := order.render_list()
+		size   := int(order.Chunk_Size)
+		list   := make([]*Chunk, 0, len(frames) / size + 1)
+
+		for i := 0; i < len(frames); i += size {
+			end := i + size - 1
+			if end >= len(frames) {
+				end = len(frames) - 1
+			}
+
+			list = append(list, &Chunk{
+				index:       len(list),
+				Start_Frame: frames[i],
+				End_Frame:   frames[end],
+			})
+		}
+
+		return list
+	}
+
+	span := int(order.Chunk_Size * step)
+	list := make([]*Chunk, 0, (order.End_Frame - order.Start_Frame) / span + 1)
+
+	for start := order.Start_Frame; start <= order.End_Frame; start += span {
+		end := start + span - int(step)
+		if end > order.End_Frame {
+			end = order.End_Frame
+		}
+
+		list = append(list, &Chunk{
+			index:       len(list),
+			Start_Frame: start,
+			End_Frame:   end,
+		})
+	}
+
+	return list
+}
+
+// chunks without a state file on disk
+// simply haven't been touched yet
+func load_chunks(project_dir string, order *Order) []*Chunk {
+	list := make_chunks(order)
+
+	for _, chunk := range list {
+		blob, ok := load_file(chunk_manifest_path(project_dir, order.Name, chunk.index))
+		if ok {
+			if _, err := toml.Decode(blob, chunk); err != nil {
+				eprintf("Failed to read chunk %d of order %q\n", chunk.index + 1, order.Name)
+			}
+		}
+
+		if lock, ok := read_lock(chunk_lock_path(project_dir, order.Name, chunk.index)); ok {
+			chunk.lock = lock
+		}
+	}
+
+	return list
+}
+
+func save_chunk(project_dir string, order *Order, chunk *Chunk) bool {
+	if !make_directory(chunk_dir(project_dir, order.Name)) {
+		return false
+	}
+
+	buffer := bytes.Buffer{}
+	buffer.Grow(128)
+
+	if err := toml.NewEncoder(&buffer).Encode(chunk); err != nil {
+		eprintln("Failed to encode chunk file")
+		return false
+	}
+
+	return write_file(chunk_manifest_path(project_dir, order.Name, chunk.index), buffer.String())
+}
+
+// finds the next chunk nobody else is working
+// on and locks it for this machine
+func claim_chunk(config *Config, order *Order) (*Chunk, bool) {
+	if !make_directory(chunk_dir(config.project_dir, order.Name)) {
+		return nil, false
+	}
+
+	for _, chunk := range load_chunks(config.project_dir, order) {
+		if chunk.Complete {
+			continue
+		}
+
+		// failed chunks are only picked up again when the
+		// failed order itself is being retried
+		if chunk.failed() && order.Status != STATUS_FAILED {
+			continue
+		}
+
+		if !can_claim(config, chunk.lock) {
+			continue
+		}
+
+		old_lock := chunk.lock
+
+		lock, ok := claim_lock(config, chunk_lock_path(config.project_dir, order.Name, chunk.index))
+		if !ok {
+			continue
+		}
+
+		chunk.lock = lock
+
+		if old_lock != nil && old_lock.is_stale(config) {
+			notify(config, stale_notice(config, order, chunk, old_lock))
+		}
+		return chunk, true
+	}
+
+	return nil, false
+}
+
+func count_complete_chunks(list []*Chunk) int {
+	count := 0
+	for _, chunk := range list {
+		if chunk.Complete {
+			count++
+		}
+	}
+	return count
+}
+
+func render_chunks(config *Config, order *Order, estimate *Queue_Estimate) Render_Result {
+	claimed := false
+	started := time.Now()
+
+	for {
+		chunk, ok := claim_chunk(config, order)
+		if !ok {
+			break
+		}
+
+		if !claimed {
+			run_hook(config, HOOK_BEFORE_ORDER, order)
+			claimed = true
+		}
+
+		printf(apply_color("[$1%s$0] chunk %d: %d -> %d\n"), order.Name, chunk.index + 1, chunk.Start_Frame, chunk.End_Frame)
+
+		lock_file := chunk_lock_path(config.project_dir, order.Name, chunk.index)
+
+		did_run := run_with_retries(config, order, chunk, estimate)
+
+		// another machine has the chunk now, so
+		// leave it to them and find another
+		if chunk.lock.is_lost() {
+			continue
+		}
+
+		if !did_run {
+			release_lock(lock_file, chunk.lock)
+			run_hook(config, HOOK_ORDER_FAILURE, order)
+			notify(config, order_notice(config, NOTIFY_ORDER_FAILED, order, time.Since(started)))
+			return RENDER_FAILED
+		}
+
+		// the chunk is marked complete before its lock
+		// goes, or someone could claim it in between
+		did_save := save_chunk(config.project_dir, order, chunk)
+
+		release_lock(lock_file, chunk.lock)
+
+		if !did_save {
+			return RENDER_FAILED
+		}
+	}
+
+	if !claimed {
+		return RENDER_SKIPPED
+	}
+
+	// other machines may still be working on their
+	// chunks, in which case the last one to finish
+	// is the one that marks the order as complete
+	// and runs the success hook
+	list := load_chunks(config.project_dir, order)
+
+	if count_complete_chunks(list) < len(list) {
+		return RENDER_DONE
+	}
+
+	// two machines can finish their last chunks at the
+	// same moment and both see every chunk complete, so
+	// whichever creates the marker first wraps it up
+	if !create_lock(complete_lock_path(config.project_dir, order.Name), new_lock(config)) {
+		return RENDER_DONE
+	}
+
+	order.Status = STATUS_COMPLETE
+	order.clear_error()
+	order.clear_redo()
+	save_run_state(config, order, nil)
+	post_render(config, order)
+	run_hook(config, HOOK_ORDER_SUCCESS, order)
+	notify(config, order_notice(config, NOTIFY_ORDER_COMPLETE, order, time.Since(started)))
+
+	return RENDER_DONE
+}
@@ -43,12 +43,17 @@ func command_init() {
 	}
 }
 
-func command_list(config *Config) {
+func command_list(config *Config, args *Arguments) {
 	queue, ok := load_orders(config.project_dir, false)
 	if !ok {
 		return
 	}
 
+	if args.output != OUTPUT_TEXT {
+		write_data(args.output, export_queue(config, queue))
+		return
+	}
+
 	if len(queue) == 0 {
 		printf("No orders found!\n")
 		return
@@ -56,11 +61,20 @@ func command_list(config *Config) {
 
 	printf("\n")
 
+	estimate := estimate_queue(config, queue)
+
 	index := 0
 	for _, order := range queue {
-		if order.Complete {
+		switch order.Status {
+		case STATUS_COMPLETE:
 			printf("✓  ")
-		} else {
+		case STATUS_FAILED:
+			printf(apply_color("$1✗$0  "))
+		default:
+			if order.Held {
+				printf("-  ")
+				break
+			}
 			index += 1
 			printf("%-3d", index)
 		}
@@ -68,9 +82,116 @@ func command_list(config *Config) {
 		printf(apply_color("[$1%s$0] %s\n"), order.Name, filepath.Base(order.Source_Path))
 
 		printf("   Using:        %s\n",       order.Blender_Target)
-		printf("   Frame Range:  %d -> %d\n", order.Start_Frame,  order.End_Frame)
+
+		if order.Status == STATUS_FAILED {
+			printf(apply_color("   Status:       $1failed$0 (%s)"), order.Error_Kind.String())
+			if order.Error_Frame != nil {
+				printf(" on frame %d", *order.Error_Frame)
+			}
+			printf(", exit code %d, attempt %d\n", order.Exit_Code, order.Attempts)
+
+			if order.Error_Message != "" {
+				printf("   Error:        %s\n", order.Error_Message)
+			}
+		} else {
+			printf("   Status:       %s\n", order_state(config, order, queue))
+		}
+
+		if len(order.After) > 0 {
+			switch dependency_state(order, queue) {
+			case DEPENDENCIES_READY:
+				printf("   After:        ready\n")
+			case DEPENDENCIES_WAITING:
+				printf("   After:        waiting\n")
+			case DEPENDENCIES_BLOCKED:
+				printf(apply_color("   After:        $1blocked$0\n"))
+			}
+			print_dependencies(order, queue, 0)
+		}
+
+		if order.Preset != "" {
+			printf("   Preset:       %s\n", order.Preset)
+		}
+
+		if order.Scene != "" {
+			printf("   Scene:        %s\n", order.Scene)
+		}
+
+		if order.View_Layer != "" {
+			printf("   View Layer:   %s\n", order.View_Layer)
+		}
+
+		if order.Camera != "" {
+			printf("   Camera:       %s\n", order.Camera)
+		}
+
+		if order.Priority != 0 {
+			printf("   Priority:     %d\n", order.Priority)
+		}
+
+		if order.Frames != "" {
+			printf("   Frames:       %s (%d frames)\n", order.Frames, len(order.frame_list()))
+		} else if order.Frame_Step > 1 {
+			printf("   Frame Range:  %d -> %d (step %d)\n", order.Start_Frame, order.End_Frame, order.Frame_Step)
+		} else {
+			printf("   Frame Range:  %d -> %d\n", order.Start_Frame, order.End_Frame)
+		}
+
+		if order.Redo_Frames != "" {
+			printf("   Redoing:      %s (%d frames)\n", order.Redo_Frames, len(order.render_list()))
+		}
+
+		if order.Chunk_Size > 0 {
+			chunks := load_chunks(config.project_dir, order)
+			locked := 0
+			for _, chunk := range chunks {
+				if chunk.lock != nil && !chunk.Complete {
+					locked++
+				}
+			}
+			printf("   Chunks:       %d/%d complete, %d rendering (%d frames each)\n", count_complete_chunks(chunks), len(chunks), locked, order.Chunk_Size)
+		} else if order.lock != nil && order.Status != STATUS_COMPLETE {
+			if order.lock.is_stale(config) {
+				printf(apply_color("   Locked By:    %s $1stale$0\n"), order.lock.Host)
+			} else {
+				printf("   Locked By:    %s\n", order.lock.Host)
+			}
+		}
+
+		if order.Chunk_Size == 0 && order.Has_Progress && order.Status != STATUS_COMPLETE {
+			printf("   Resume From:  %d\n", order.resume_frame())
+		}
+
+		if progress, ok := estimate.progress[order.Name]; ok {
+			if d, ok := progress.eta(); ok {
+				printf("   ETA:          %s", format_duration(d))
+				if progress.frame_time > 0 {
+					printf(" (%s a frame)", format_seconds(progress.frame_time))
+				}
+				printf("\n")
+			}
+		}
+
 		printf("   Resolution:   %d x %d\n",  order.Resolution_X, order.Resolution_Y)
 
+		print_quality(order)
+
+		if order.File_Format != "" {
+			printf("   Format:       %s\n", format_image_type(order))
+		}
+
+		if order.Encode != nil {
+			printf("   Encode:       %s (%s)\n", order.Encode.Name, get_video_codec(order.Encode.Codec).name)
+
+			if order.Encoded != nil {
+				if order.Encoded.Status == ENCODE_FAILED {
+					printf(apply_color("   Encoded:      $1failed$0 (%s)\n"), order.Encoded.Error)
+				} else {
+					printf("   Encoded:      %s\n", order.Encoded.Path)
+				}
+			}
+		}
+
 		printf("   Placeholders: %s\n", format_fallback_bool(order.Use_Placeholders))
 		printf("   Overwriting:  %s\n", format_fallback_bool(order.Overwrite))
 
@@ -82,6 +203,14 @@ func command_list(config *Config) {
 
 		printf("\n")
 	}
+
+	if d, ok := estimate.eta(); ok {
+		printf("Queue ETA: %s", format_duration(d))
+		if estimate.hosts > 1 {
+			printf(" across %d machines", estimate.hosts)
+		}
+		printf("\n\n")
+	}
 }
 
 func command_clean(config *Config, args *Arguments) {
@@ -96,7 +225,7 @@ func command_clean(config *Config, args *Arguments) {
 
 	can_remove_any := false
 	for _, order := range queue {
-		if args.hard_clean || order.Complete {
+		if args.hard_clean || order.Status == STATUS_COMPLETE {
 			can_remove_any = true
 			break
 		}
@@ -110,7 +239,7 @@ func command_clean(config *Config, args *Arguments) {
 	count := 0
 
 	for _, order := range queue {
-		if args.hard_clean || order.Complete {
+		if args.hard_clean || order.Status == STATUS_COMPLETE {
 			remove_file(order_path(config.project_dir, order.Name))
 			printf(apply_color("[$1%s$0] removed!\n"), order.Name)
 			count += 1
@@ -126,21 +255,64 @@ func command_redo(config *Config, args *Arguments) {
 		return
 	}
 
-	for _, order := range queue {
-		if order.Name == args.source_path {
-			order.Complete = false
-			order.Time     = time.Now()
-
-			save_order(order, manifest_path(config.project_dir, order.Name))
-			os.Remove(lock_path(config.project_dir, order.Name))
-			break
-		}
+	if order, ok := find_order(queue, args.source_path); ok {
+		redo_order(config, order, args.frames)
 	}
 
 	// @todo if something else removes its lock file,
 	// Sous Chef should probably stop that job for safety reasons
 }
 
+// resets an order back into the queue.  giving it
+// frames renders just those of the order's frames
+// again, overwriting them even if the order says not
+// to, because they're presumably broken
+func redo_order(config *Config, order *Order, frames string) bool {
+	order.clear_redo()
+
+	if frames != "" {
+		// markers need the file, which we don't open here
+		subset := &Order{}
+		if !apply_frames(subset, frames, &Scene_Info{Name: order.Scene}) {
+			return false
+		}
+
+		wanted := make(map[int]bool, 64)
+		for _, frame := range subset.frame_list() {
+			wanted[frame] = true
+		}
+
+		list := make([]int, 0, len(wanted))
+		for _, frame := range order.frame_list() {
+			if wanted[frame] {
+				list = append(list, frame)
+			}
+		}
+
+		if len(list) == 0 {
+			eprintf(apply_color("[$1%s$0] doesn't render any of the frames %s\n"), order.Name, frames)
+			return false
+		}
+
+		order.Redo_Frames = format_frames(list, "-")
+	}
+
+	order.Status       = STATUS_PENDING
+	order.Attempts     = 0
+	order.Has_Progress = false
+	order.Last_Frame   = 0
+	order.Time         = time.Now()
+
+	order.clear_error()
+
+	order.Encoded = nil
+
+	os.Remove(lock_path(config.project_dir, order.Name))
+	os.RemoveAll(chunk_dir(config.project_dir, order.Name))
+
+	return save_order(order, manifest_path(config.project_dir, order.Name))
+}
+
 func command_delete(config *Config, args *Arguments) {
 	queue, ok := load_orders(config.project_dir, false)
 	if !ok {
@@ -155,7 +327,151 @@ func command_delete(config *Config, args *Arguments) {
 	}
 }
 
+func command_hold(config *Config, args *Arguments, hold bool) {
+	if len(args.names) == 0 {
+		eprintln("No order names were provided!")
+		return
+	}
+
+	queue, ok := load_orders(config.project_dir, false)
+	if !ok {
+		return
+	}
+
+outer:
+	for _, name := range args.names {
+		for _, order := range queue {
+			if order.Name != name {
+				continue
+			}
+
+			order.Held = hold
+
+			did_save := update_order(config.project_dir, order.Name, func(current *Order) {
+				current.Held = hold
+			})
+			if !did_save {
+				continue outer
+			}
+
+			if hold {
+				printf(apply_color("[$1%s$0] held\n"), order.Name)
+			} else {
+				printf(apply_color("[$1%s$0] released\n"), order.Name)
+			}
+			continue outer
+		}
+
+		eprintf(apply_color("Order $1%q$0 does not exist\n"), name)
+	}
+}
+
+// moving an order takes on the priority of its new
+// neighbour and a timestamp that sorts it into place,
+// so nothing else in the queue has to be rewritten
+func command_move(config *Config, args *Arguments) {
+	queue, ok := load_orders(config.project_dir, false)
+	if !ok {
+		return
+	}
+
+	move_order(config, queue, args.source_path, args.move_to, args.move_target)
+}
+
+func move_order(config *Config, queue Order_Array, name, move_to, move_target string) (*Order, bool) {
+	var the_order *Order
+
+	others := make([]*Order, 0, len(queue))
+
+	for _, order := range queue {
+		if order.Name == name {
+			the_order = order
+		} else {
+			others = append(others, order)
+		}
+	}
+
+	if the_order == nil {
+		eprintf(apply_color("Order $1%q$0 does not exist\n"), name)
+		return nil, false
+	}
+
+	if len(others) == 0 {
+		return the_order, true
+	}
+
+	target := -1
+	if move_to == "before" || move_to == "after" {
+		for i, order := range others {
+			if order.Name == move_target {
+				target = i
+				break
+			}
+		}
+
+		if target < 0 {
+			eprintf(apply_color("Order $1%q$0 does not exist\n"), move_target)
+			return nil, false
+		}
+	}
+
+	switch move_to {
+	case "top":
+		the_order.Priority = others[0].Priority
+		the_order.Time     = others[0].Time.Add(-time.Second)
+
+	case "bottom":
+		last := others[len(others) - 1]
+		the_order.Priority = last.Priority
+		the_order.Time     = last.Time.Add(time.Second)
+
+	case "before":
+		the_order.Priority = others[target].Priority
+		the_order.Time     = others[target].Time.Add(-time.Second)
+
+		if target > 0 && others[target - 1].Priority == the_order.Priority {
+			prev := others[target - 1]
+			the_order.Time = prev.Time.Add(others[target].Time.Sub(prev.Time) / 2)
+		}
+
+	case "after":
+		the_order.Priority = others[target].Priority
+		the_order.Time     = others[target].Time.Add(time.Second)
+
+		if target < len(others) - 1 && others[target + 1].Priority == the_order.Priority {
+			next := others[target + 1]
+			the_order.Time = others[target].Time.Add(next.Time.Sub(others[target].Time) / 2)
+		}
+
+	default:
+		eprintln("Move needs one of --top, --bottom, --before or --after")
+		return nil, false
+	}
+
+	did_save := update_order(config.project_dir, the_order.Name, func(current *Order) {
+		current.Priority = the_order.Priority
+		current.Time     = the_order.Time
+	})
+
+	return the_order, did_save
+}
+
 func command_targets(config *Config, args *Arguments) {
+	if args.output != OUTPUT_TEXT {
+		data := &Export_Targets{
+			Schema_Version: SCHEMA_VERSION,
+			Default_Target: config.Default_Target,
+			Targets:        make([]*Export_Target, 0, len(config.Blender_Target)),
+		}
+
+		for _, t := range config.Blender_Target {
+			data.Targets = append(data.Targets, &Export_Target{t.Name, t.Path, file_exists(t.Path)})
+		}
+
+		write_data(args.output, data)
+		return
+	}
+
 	if len(config.Blender_Target) == 0 {
 		printf("No Blender targets in config.toml\n")
 		return
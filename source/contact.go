@@ -0,0 +1,344 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "os"
+import "fmt"
+import "image"
+import "strings"
+import "image/png"
+import "image/color"
+import "path/filepath"
+
+const (
+	DEFAULT_CONTACT_COLUMNS = 6
+	DEFAULT_CONTACT_WIDTH   = 320
+	DEFAULT_CONTACT_OUTPUT  = "{output}/{order}_contact.png"
+
+	// without an explicit step, one is chosen
+	// so the sheet has no more than this many
+	MAX_CONTACT_FRAMES = 36
+
+	CONTACT_PADDING = 8
+	CONTACT_LABEL   = GLYPH_HEIGHT * 2 + CONTACT_PADDING
+	CONTACT_HEADER  = GLYPH_HEIGHT * 2 + CONTACT_PADDING * 2
+)
+
+var (
+	contact_background = color.RGBA{0x1d, 0x1f, 0x21, 0xff}
+	contact_tile       = color.RGBA{0x28, 0x2a, 0x2e, 0xff}
+	contact_text       = color.RGBA{0xc5, 0xc8, 0xc6, 0xff}
+	contact_accent     = color.RGBA{0xde, 0x93, 0x5f, 0xff}
+	contact_missing    = color.RGBA{0xcc, 0x66, 0x66, 0xff}
+)
+
+type Contact_Config struct {
+	Every        uint   `toml:"every"`
+	Columns      uint   `toml:"columns"`
+	Width        uint   `toml:"width"`
+	Output       string `toml:"output"`
+	After_Render bool   `toml:"after_render"`
+}
+
+type Contact_Frame struct {
+	frame int
+	image image.Image // nil if it couldn't be read
+}
+
+func command_contact(config *Config, args *Arguments) {
+	queue, ok := load_orders(config.project_dir, false)
+	if !ok {
+		return
+	}
+
+	order, ok := find_order(queue, args.source_path)
+	if !ok {
+		eprintf(apply_color("Order $1%q$0 does not exist\n"), args.source_path)
+		return
+	}
+
+	settings := config.Contact
+
+	if args.contact_every > 0 {
+		settings.Every = args.contact_every
+	}
+	if args.contact_columns > 0 {
+		settings.Columns = args.contact_columns
+	}
+
+	make_contact_sheet(config, order, &settings)
+}
+
+func make_contact_sheet(config *Config, order *Order, settings *Contact_Config) bool {
+	basename := filepath.Base(order.Source_Path)
+
+	printf(apply_color("[$1%s$0] gathering frames for contact sheet..."), order.Name)
+
+	outputs, ok := verify_outputs(config, order)
+
+	printf(RESET_LINE)
+
+	if !ok {
+		eprintf("Failed to gather outputs from %s!\n", basename)
+		return false
+	}
+
+	output, ok := contact_output(outputs)
+	if !ok {
+		eprintf(apply_color("[$1%s$0] has no PNG or JPEG output for a contact sheet\n"), order.Name)
+		return false
+	}
+
+	frames := order.frame_list()
+	if len(frames) != len(output.Files) {
+		eprintf(apply_color("[$1%s$0] doesn't match its outputs: has the file changed?\n"), order.Name)
+		return false
+	}
+
+	every := int(settings.Every)
+	if every == 0 {
+		every = (len(frames) + MAX_CONTACT_FRAMES - 1) / MAX_CONTACT_FRAMES
+	}
+
+	list := make([]*Contact_Frame, 0, len(frames) / every + 1)
+
+	for i := 0; i < len(frames); i += every {
+		list = append(list, &Contact_Frame{
+			frame: frames[i],
+			image: read_image(output.Files[i]),
+		})
+	}
+
+	header := fmt.Sprintf("%s  |  %s", basename, format_frames(frames, "-"))
+	if every > 1 {
+		header += fmt.Sprintf("  |  every %d", every)
+	}
+
+	sheet := compose_contact_sheet(order.Name, header, list, settings)
+
+	template := settings.Output
+	if template == "" {
+		template = DEFAULT_CONTACT_OUTPUT
+	}
+
+	path := order_output_path(config, order, template)
+
+	if !make_directory(filepath.Dir(path)) {
+		return false
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		eprintf("Failed to create %q\n", path)
+		return false
+	}
+	defer file.Close()
+
+	if err := png.Encode(file, sheet); err != nil {
+		eprintf("Failed to write %q\n", path)
+		return false
+	}
+
+	printf(apply_color("[$1%s$0] contact sheet %s ✓\n"), order.Name, path)
+	return true
+}
+
+// the scene's own output if it can be read, otherwise
+// the first File Output node that can, for scenes that
+// render EXRs but also write out previews
+func contact_output(outputs []*Output_Check) (*Output_Check, bool) {
+	for _, output := range outputs {
+		if len(output.Files) == 0 {
+			continue
+		}
+
+		switch strings.ToLower(filepath.Ext(output.Files[0])) {
+		case ".png", ".jpg", ".jpeg":
+			return output, true
+		}
+	}
+	return nil, false
+}
+
+// the decoders are registered by verify's imports
+func read_image(path string) image.Image {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil
+	}
+	return img
+}
+
+func compose_contact_sheet(name, header string, list []*Contact_Frame, settings *Contact_Config) *image.RGBA {
+	columns := int(settings.Columns)
+	if columns == 0 {
+		columns = DEFAULT_CONTACT_COLUMNS
+	}
+	if columns > len(list) {
+		columns = len(list)
+	}
+
+	rows := (len(list) + columns - 1) / columns
+
+	tile_w := int(settings.Width)
+	if tile_w == 0 {
+		tile_w = DEFAULT_CONTACT_WIDTH
+	}
+
+	// every tile takes the shape of the first
+	// frame that could be read, or 16:9
+	tile_h := tile_w * 9 / 16
+	for _, entry := range list {
+		if entry.image != nil {
+			size := entry.image.Bounds().Size()
+			tile_h = tile_w * size.Y / size.X
+			break
+		}
+	}
+
+	width  := CONTACT_PADDING + columns * (tile_w + CONTACT_PADDING)
+	height := CONTACT_HEADER + rows * (tile_h + CONTACT_LABEL + CONTACT_PADDING)
+
+	sheet := image.NewRGBA(image.Rect(0, 0, width, height))
+	fill_rect(sheet, sheet.Bounds(), contact_background)
+
+	// the name goes first in the accent colour, then as
+	// much of the rest as there's room for
+	x := CONTACT_PADDING
+	draw_text(sheet, x, CONTACT_PADDING, name, 2, contact_accent)
+	x += text_width(name + "  ", 2)
+
+	room := (width - CONTACT_PADDING - x) / (GLYPH_ADVANCE * 2)
+	if room > 0 {
+		if runes := []rune(header); len(runes) > room {
+			header = string(runes[:room])
+		}
+		draw_text(sheet, x, CONTACT_PADDING, header, 2, contact_text)
+	}
+
+	for i, entry := range list {
+		x := CONTACT_PADDING + (i % columns) * (tile_w + CONTACT_PADDING)
+		y := CONTACT_HEADER + (i / columns) * (tile_h + CONTACT_LABEL + CONTACT_PADDING)
+
+		tile := image.Rect(x, y, x + tile_w, y + tile_h)
+		fill_rect(sheet, tile, contact_tile)
+
+		label := fmt.Sprint(entry.frame)
+
+		if entry.image == nil {
+			label += " missing"
+			draw_text(sheet, x, y + tile_h + CONTACT_PADDING / 2, label, 2, contact_missing)
+			continue
+		}
+
+		scale_into(sheet, fit_rect(tile, entry.image.Bounds().Size()), entry.image)
+		draw_text(sheet, x, y + tile_h + CONTACT_PADDING / 2, label, 2, contact_text)
+	}
+
+	return sheet
+}
+
+func fill_rect(img *image.RGBA, rect image.Rectangle, c color.RGBA) {
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// the largest rectangle with the source's aspect
+// that fits in the tile, centred within it
+func fit_rect(tile image.Rectangle, size image.Point) image.Rectangle {
+	w, h := tile.Dx(), tile.Dy()
+
+	if size.X * h > size.Y * w {
+		h = w * size.Y / size.X
+	} else {
+		w = h * size.X / size.Y
+	}
+
+	x := tile.Min.X + (tile.Dx() - w) / 2
+	y := tile.Min.Y + (tile.Dy() - h) / 2
+
+	return image.Rect(x, y, x + w, y + h)
+}
+
+// averages every source pixel that lands in each target
+// pixel, which is slow-ish but looks far better than
+// nearest neighbour at thumbnail sizes, composited
+// over whatever's already there
+func scale_into(dst *image.RGBA, rect image.Rectangle, src image.Image) {
+	bounds := src.Bounds()
+
+	w, h   := rect.Dx(), rect.Dy()
+	sw, sh := bounds.Dx(), bounds.Dy()
+
+	if w <= 0 || h <= 0 || sw <= 0 || sh <= 0 {
+		return
+	}
+
+	for y := 0; y < h; y++ {
+		y0 := bounds.Min.Y + y * sh / h
+		y1 := bounds.Min.Y + (y + 1) * sh / h
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+
+		for x := 0; x < w; x++ {
+			x0 := bounds.Min.X + x * sw / w
+			x1 := bounds.Min.X + (x + 1) * sw / w
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n uint64
+
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					b += uint64(pb)
+					a += uint64(pa)
+					n++
+				}
+			}
+
+			r, g, b, a = r / n, g / n, b / n, a / n
+
+			// the colours are premultiplied, so the
+			// background only shows through by 1 - a
+			under := dst.RGBAAt(rect.Min.X + x, rect.Min.Y + y)
+			rest  := 0xffff - a
+
+			dst.SetRGBA(rect.Min.X + x, rect.Min.Y + y, color.RGBA{
+				uint8((r + uint64(under.R) * 0x101 * rest / 0xffff) >> 8),
+				uint8((g + uint64(under.G) * 0x101 * rest / 0xffff) >> 8),
+				uint8((b + uint64(under.B) * 0x101 * rest / 0xffff) >> 8),
+				0xff,
+			})
+		}
+	}
+}
@@ -0,0 +1,152 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "io"
+import "os"
+import "embed"
+import "strings"
+import "strconv"
+import "io/fs"
+import "net/http"
+
+const (
+	DEFAULT_LOG_LINES = 40
+	MAX_LOG_LINES     = 1000
+
+	// how far back from the end of a log to look
+	// for lines, so huge logs aren't read whole
+	LOG_TAIL_BYTES = 256 * 1024
+)
+
+// the dashboard is plain static files that talk to the
+// same API as everyone else, compiled into the binary
+// so serve stays a single, dependency-free executable
+
+//go:embed dashboard
+var dashboard_files embed.FS
+
+var dashboard_handler = func() http.Handler {
+	sub, err := fs.Sub(dashboard_files, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(sub))
+}()
+
+type Export_Log struct {
+	Schema_Version int      `json:"schema_version"`
+	Order          string   `json:"order"`
+	File           string   `json:"file"`
+	Lines          []string `json:"lines"`
+}
+
+func serve_dashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		write_failure(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	dashboard_handler.ServeHTTP(w, r)
+}
+
+func (server *Server) order_log(w http.ResponseWriter, r *http.Request, name string) {
+	_, order, ok := server.find(w, name)
+	if !ok {
+		return
+	}
+
+	count := DEFAULT_LOG_LINES
+
+	if value := r.URL.Query().Get("lines"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			write_failure(w, http.StatusBadRequest, "invalid line count " + value)
+			return
+		}
+		count = n
+		if count > MAX_LOG_LINES {
+			count = MAX_LOG_LINES
+		}
+	}
+
+	data := &Export_Log{
+		Schema_Version: SCHEMA_VERSION,
+		Order:          order.Name,
+		Lines:          []string{},
+	}
+
+	// only the newest run is shown; chunked orders
+	// might have several going at once, but the
+	// newest is the one most likely to be of interest
+	if list := list_logs(server.config.project_dir, order.Name); len(list) > 0 {
+		lines, ok := tail_lines(list[0], count)
+		if !ok {
+			write_failure(w, http.StatusInternalServerError, "failed to read log " + list[0])
+			return
+		}
+		data.File  = list[0]
+		data.Lines = lines
+	}
+
+	write_json(w, http.StatusOK, data)
+}
+
+func tail_lines(path string, count int) ([]string, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, false
+	}
+
+	offset := info.Size() - LOG_TAIL_BYTES
+	if offset < 0 {
+		offset = 0
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, false
+	}
+
+	blob, err := io.ReadAll(file)
+	if err != nil {
+		return nil, false
+	}
+
+	lines := strings.Split(strings.TrimRight(string(blob), "\n"), "\n")
+
+	// the first line is probably cut in half
+	if offset > 0 && len(lines) > 1 {
+		lines = lines[1:]
+	}
+
+	if len(lines) == 1 && lines[0] == "" {
+		return []string{}, true
+	}
+
+	if len(lines) > count {
+		lines = lines[len(lines) - count:]
+	}
+
+	return lines, true
+}
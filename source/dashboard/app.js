@@ -0,0 +1,215 @@
+// the dashboard is a thin skin over the serve API:
+// it polls the queue and calls the same endpoints
+// any other tool would
+
+const REFRESH = 3000;
+const LOG_LINES = 60;
+
+let selected = null;
+
+function element(tag, attributes = {}, ...children) {
+	const node = document.createElement(tag);
+	for (const [key, value] of Object.entries(attributes)) {
+		if (key.startsWith("on")) {
+			node.addEventListener(key.slice(2), value);
+		} else {
+			node.setAttribute(key, value);
+		}
+	}
+	for (const child of children) {
+		node.append(child);
+	}
+	return node;
+}
+
+function duration(seconds) {
+	seconds = Math.round(seconds);
+	const h = Math.floor(seconds / 3600);
+	const m = Math.floor(seconds / 60) % 60;
+	const s = seconds % 60;
+	if (h > 0) return `${h}h${String(m).padStart(2, "0")}m`;
+	if (m > 0) return `${m}m${String(s).padStart(2, "0")}s`;
+	return `${s}s`;
+}
+
+function show_error(message) {
+	const node = document.getElementById("error");
+	node.textContent = message;
+	node.hidden = !message;
+}
+
+async function request(method, path, body) {
+	const options = { method };
+	if (method !== "GET") {
+		options.headers = { "Content-Type": "application/json" };
+	}
+	if (body !== undefined) {
+		options.body = JSON.stringify(body);
+	}
+
+	const response = await fetch(path, options);
+	if (response.status === 204) {
+		return null;
+	}
+
+	const data = await response.json();
+	if (!response.ok) {
+		throw new Error(data.error);
+	}
+	return data;
+}
+
+async function action(method, path, body) {
+	try {
+		await request(method, path, body);
+		show_error("");
+	} catch (error) {
+		show_error(error.message);
+	}
+	refresh();
+}
+
+function progress_cell(order) {
+	const progress = order.progress;
+	const bar = element("div", { class: "bar " + order.state },
+		element("div", { style: `width: ${progress.percentage}%` }));
+
+	let detail = `${progress.done}/${progress.total} frames, ${progress.percentage}%`;
+	if (progress.chunks_total !== null) {
+		detail += `, ${progress.chunks_complete}/${progress.chunks_total} chunks`;
+	}
+	if (progress.eta_seconds !== null) {
+		detail += `, ${duration(progress.eta_seconds)} left`;
+	}
+
+	return element("td", {}, bar, element("div", { class: "detail" }, detail));
+}
+
+function machines_cell(order) {
+	const cell = element("td");
+	for (const lock of order.locks) {
+		let text = lock.host;
+		if (lock.chunk !== null) text += ` (chunk ${lock.chunk})`;
+		if (lock.frame !== null) text += ` on ${lock.frame}`;
+		cell.append(element("div", { class: lock.stale ? "stale" : "" }, lock.stale ? text + " — stale" : text));
+	}
+	return cell;
+}
+
+function actions_cell(order, index, orders) {
+	const path = `/api/orders/${encodeURIComponent(order.name)}`;
+	const cell = element("td");
+
+	const button = (label, handler, kind = "") =>
+		cell.append(element("button", { class: kind, onclick: handler }, label));
+
+	if (index > 0) {
+		button("↑", () => action("POST", path + "/move", { to: "before", target: orders[index - 1].name }));
+	}
+	if (index < orders.length - 1) {
+		button("↓", () => action("POST", path + "/move", { to: "after", target: orders[index + 1].name }));
+	}
+
+	button("top", () => action("POST", path + "/move", { to: "top" }));
+
+	if (order.held) {
+		button("release", () => action("POST", path + "/release"));
+	} else {
+		button("hold", () => action("POST", path + "/hold"));
+	}
+
+	button("redo", () => {
+		if (confirm(`Reset ${order.name} and render it again from the start?`)) {
+			action("POST", path + "/redo");
+		}
+	});
+
+	button("delete", () => {
+		if (confirm(`Delete ${order.name}? This can't be undone.`)) {
+			if (selected === order.name) selected = null;
+			action("DELETE", path);
+		}
+	}, "danger");
+
+	return cell;
+}
+
+function render_queue(data) {
+	const body = document.querySelector("#queue tbody");
+	body.replaceChildren();
+
+	let index = 0;
+	let rendering = 0;
+
+	data.orders.forEach((order, i, orders) => {
+		let marker = "✓";
+		if (order.status === "failed") {
+			marker = "✗";
+		} else if (order.status !== "complete") {
+			marker = order.held ? "-" : String(++index);
+		}
+
+		if (order.state === "rendering") rendering++;
+
+		let state = order.state;
+		if (order.error !== null) state += ` (${order.error.kind})`;
+
+		const row = element("tr", { class: order.name === selected ? "selected" : "" },
+			element("td", {}, marker),
+			element("td", { class: "name", title: "Show log", onclick: () => select(order.name) }, order.name),
+			element("td", { title: order.source_path }, order.source_path.split("/").pop()),
+			element("td", {}, element("span", { class: "state " + order.state }, state)),
+			progress_cell(order),
+			machines_cell(order),
+			actions_cell(order, i, orders),
+		);
+
+		body.append(row);
+	});
+
+	document.getElementById("empty").hidden = data.orders.length > 0;
+	document.getElementById("summary").textContent =
+		`${data.orders.length} orders, ${rendering} rendering, updated ${new Date().toLocaleTimeString()}`;
+}
+
+async function render_log() {
+	const section = document.getElementById("log");
+	if (selected === null) {
+		section.hidden = true;
+		return;
+	}
+
+	try {
+		const data = await request("GET", `/api/orders/${encodeURIComponent(selected)}/log?lines=${LOG_LINES}`);
+		const text = document.getElementById("log-text");
+		const at_bottom = text.scrollTop + text.clientHeight >= text.scrollHeight - 4;
+
+		document.getElementById("log-name").textContent = selected;
+		document.getElementById("log-file").textContent = data.file || "No logs yet";
+		text.textContent = data.lines.join("\n");
+		section.hidden = false;
+
+		if (at_bottom) {
+			text.scrollTop = text.scrollHeight;
+		}
+	} catch (error) {
+		section.hidden = true;
+	}
+}
+
+function select(name) {
+	selected = selected === name ? null : name;
+	refresh();
+}
+
+async function refresh() {
+	try {
+		render_queue(await request("GET", "/api/orders"));
+		await render_log();
+	} catch (error) {
+		show_error(error.message);
+	}
+}
+
+refresh();
+setInterval(refresh, REFRESH);
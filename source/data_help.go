@@ -42,6 +42,18 @@ $1Commands$0
     $1redo$0     reset an order so it can run again
     $1delete$0   delete an order immediately
     $1targets$0  view Blender targets
+    $1locks$0    view or break order locks
+    $1log$0      print an order's Blender logs
+    $1move$0     move an order within the queue
+    $1hold$0     stop orders from rendering for now
+    $1release$0  let held orders render again
+    $1verify$0   check an order's rendered frames
+    $1status$0   show live progress of the queue
+    $1serve$0    run the HTTP API for the queue
+    $1notify$0   send a test notification
+    $1encode$0   encode a finished order to video
+    $1contact$0  make a contact sheet of an order
+    $1stats$0    show frame times and machine hours
 
     $1help$0     print this message and others
     $1version$0  print the version information
@@ -66,6 +78,30 @@ $1Hard$0
     $1--hard$0
 
 Removes $1all$0 orders, regardless of status.
+`
+		case "contact":
+			return `
+Contact tiles a sample of a finished order's frames into a 
+single PNG, labelled with the order's name and each frame 
+number, for a quick look over the whole shot.
+
+$1Contact Usage$0
+-------------
+
+    $1contact name [--every N] [--columns N]$0
+
+$1--every$0 takes every Nth frame; without it, enough are 
+skipped to keep the sheet to 36 frames or fewer.
+
+$1--columns$0 sets how many frames go across the sheet, which 
+is 6 by default.
+
+Frames are read from the scene's output if it's a PNG or JPEG, 
+or otherwise from the first File Output node that is.  Missing 
+frames are marked as such.
+
+The sheet is written to $1{output}/{order}_contact.png$0 unless 
+the $1[contact]$0 section in $1config.toml$0 says otherwise.
 `
 		case "delete":
 			return `
@@ -75,6 +111,33 @@ $1Redo Usage$0
 ----------
 
     $1delete [name]$0
+`
+		case "encode":
+			return `
+Encode runs a finished order's video encode again, such as 
+after a failed encode or after re-rendering a few frames.
+
+$1Encode Usage$0
+------------
+
+    $1encode name [--encode spec]$0
+
+$1--encode$0 chooses one of the $1[[encode]]$0 sections in 
+$1config.toml$0 for an order that didn't ask for one, or swaps 
+its encode for another.
+
+The result is recorded in the order and shown by $1list$0.
+`
+		case "hold":
+			return `
+Hold keeps orders in the queue, but stops them from being 
+rendered until they're released.  Their settings, cached files 
+and progress are all left alone.
+
+$1Hold Usage$0
+----------
+
+    $1hold [name...]$0
 `
 		case "init":
 			return `
@@ -95,12 +158,156 @@ $1Init Usage$0
 		case "list":
 			return `
 List lists all orders in the current queue and reflects their 
-status (complete or not), if applicable.
+status — pending, rendering, failed or complete — along 
+with the error details of any failed orders.  Chunked orders 
+also show how many of their chunks are complete or being 
+rendered.
+
+Orders still to render show an ETA based on their frame times 
+so far, with one for the whole queue at the end.  See $1help 
+stats$0.
 
 $1List Usage$0
 ----------
 
     $1list$0
+
+$1Output$0
+------
+
+    $1--json$0
+    $1--toml$0
+
+Prints the same information in a machine-readable form.  See 
+the readme for the schema.
+`
+		case "locks":
+			return `
+Locks lists every lock held in the queue, including the locks 
+on individual chunks, showing the machine holding it, its age 
+and the time since its last heartbeat.
+
+Locks with no heartbeat for longer than $1lock_timeout$0 
+minutes (10 by default) are stale and may be claimed by any 
+machine.
+
+$1Locks Usage$0
+-----------
+
+    $1locks [--flags]$0
+
+$1Break$0
+-----
+
+    $1--break name$0
+
+Releases all of the locks held on an order without resetting 
+its completion or progress.
+
+$1Output$0
+------
+
+    $1--json$0
+    $1--toml$0
+
+Prints the same information in a machine-readable form.  See 
+the readme for the schema.
+`
+		case "log":
+			return `
+Log prints the saved Blender output of an order.  Every run of 
+Blender keeps its stdout and stderr in a timestamped file in 
+the order's $1logs$0 directory.
+
+Only the most recent runs are kept, 10 by default, which can be 
+changed with $1log_limit$0 in $1config.toml$0.
+
+$1Log Usage$0
+---------
+
+    $1log [name] [--flags]$0
+
+$1Run$0
+---
+
+    $1--run 2$0
+
+Prints an older run instead of the latest: $11$0 is the most 
+recent, $12$0 the one before it, and so on.
+
+$1Follow$0
+------
+
+    $1--follow$0
+
+Keeps printing new output as it arrives, including from renders 
+running on other machines sharing the queue.
+`
+		case "move":
+			return `
+Move changes the position of an order in the queue.  The order 
+takes on the priority of its new neighbours, so it lands 
+exactly where it was asked to go.
+
+$1Move Usage$0
+----------
+
+    $1move [name] [--flags]$0
+
+$1Top$0
+---
+
+    $1--top$0
+
+Moves the order ahead of everything else in the queue.
+
+$1Bottom$0
+------
+
+    $1--bottom$0
+
+Moves the order behind everything else in the queue.
+
+$1Before$0
+------
+
+    $1--before other$0
+
+Places the order immediately ahead of another.
+
+$1After$0
+-----
+
+    $1--after other$0
+
+Places the order immediately behind another.
+`
+		case "notify":
+			return `
+Notify sends an example notification through the $1[[notify]]$0 
+sections in $1config.toml$0, so they can be tried out without 
+waiting for a real render to finish or fail.
+
+$1Notify Usage$0
+------------
+
+    $1notify [name] [--event order_failed]$0
+
+Without a name, every notifier is tried.  Notifiers without a 
+$1name$0 are called by their kind and position, such as 
+$1webhook 1$0.
+
+$1Events$0
+------
+
+    $1--event order_complete$0
+    $1--event order_failed$0
+    $1--event queue_finished$0
+    $1--event stale_lock$0
+    $1--event encode_failed$0
+
+Chooses which event to send an example of.  The default is 
+$1order_complete$0.
 `
 		case "order":
 			return `
@@ -155,6 +362,17 @@ $1Target$0
 Select a Blender target for your order.  Use $1souschef 
 targets$0 to see the available list in the current project.
 
+$1Preset$0
+------
+
+    $1--preset name$0
+
+Apply a named preset from $1config.toml$0.  Presets can set the 
+resolution, resolution percentage, frame step, output format, 
+encode, placeholders, overwrite and any of the quality 
+settings.  Any flag given explicitly on the command line takes 
+precedence over the preset.
+
 $1Replace$0
 -------
 
@@ -199,36 +417,291 @@ There is also a minimal shortcut table of common resolutions:
     $1DCP4K$0   4096 x 1716
     $1DCP2K$0   2048 x 858
 
+$1Format$0
+------
+
+    $1--format exr$0
+    $1--format exr:32:dwaa$0
+    $1-F png:16$0
+
+Overrides the output image format, optionally with a colour 
+depth and codec.  Either Blender's name for the format or a 
+file extension is accepted.  When omitted, the format is 
+inferred from the output path's extension, if it has one, or 
+otherwise left to the Blender file.
+
+    $1OPEN_EXR$0             exr           16, 32
+    $1OPEN_EXR_MULTILAYER$0  multilayer    16, 32
+    $1PNG$0                  png           8, 16
+    $1TIFF$0                 tif tiff      8, 16
+    $1DPX$0                  dpx           8, 10, 12, 16
+    $1JPEG2000$0             jp2 j2c       8, 12, 16
+
+The codecs are NONE, PXR24, ZIP, PIZ, RLE, ZIPS, B44, B44A, 
+DWAA and DWAB for either kind of EXR, NONE, DEFLATE, LZW and 
+PACKBITS for TIFF, and JP2 and J2K for JPEG 2000.  JPEG, 
+CINEON, BMP, IRIS, TARGA, TARGA_RAW, HDR and WEBP have no 
+options.
+
+$1Quality$0
+-------
+
+    $1--engine cycles|eevee|workbench$0
+    $1--samples 256$0
+    $1--adaptive 0.01$0
+    $1--denoise yes|no$0
+    $1--bounces 8$0
+    $1--simplify 2$0
+    $1--motion-blur yes|no$0
+
+Overrides the render engine, samples, Cycles adaptive sampling 
+threshold, Cycles denoiser, maximum light bounces, simplify 
+subdivision level (0-6) and motion blur.  Omission of any of 
+these flags will leave that decision to the Blender file.
+
 $1Frame$0
 -----
 
     $1-f 48$0
     $1--frame 1:250$0
+    $1--frame 1:250:2$0
+    $1--frame 1,10,20-40,100$0
+    $1--frame @intro:@outro$0
 
-Overrides the frame-range of the output.  If only one value is 
+Overrides the frames of the output.  If only one value is 
 supplied, it will used as the end frame, with the starting 
-frame assumed to be 1.
+frame assumed to be 1.  A third value in a range is the frame 
+step.
+
+Comma-separated lists render only those frames and ranges.  
+Negative frames are allowed, such as $1-f -24:100$0.  Timeline 
+markers can be used in place of frame numbers by prefixing 
+their names with $1@$0; a marker alone runs until the next 
+marker.
+
+$1Scene$0
+-----
+
+    $1--scene name$0
+    $1--view-layer name$0
+    $1--camera name$0
+    $1--all-scenes$0
+
+Selects the scene, view layer and camera to render.  Omitting 
+them leaves the decision to the Blender file: the active scene, 
+its enabled view layers and its active camera.  Frame range and 
+resolution are read from the selected scene.
+
+$1--all-scenes$0 creates one order for each scene in the file, 
+each with its own frame range and resolution.  Scenes without 
+the view layer or camera given are skipped.
+
+$1Chunk$0
+-----
+
+    $1--chunk 50$0
+
+Splits the order into chunks of this many frames, which can 
+each be claimed by a different machine rendering from the same 
+queue.  The project default can be set with $1default_chunk$0 
+in $1config.toml$0.
+
+$1Retry$0
+-----
+
+    $1--retry 3$0
+    $1--retry-delay 60$0
+
+Overrides the retry policy for this order: how many times a 
+failed render is retried and the number of seconds to wait 
+before the first retry.  These take precedence over 
+$1config.toml$0 and its rules for specific kinds of error, 
+except for errors it never retries.
+
+$1Encode$0
+------
+
+    $1--encode name$0
+
+Encodes the frames into a video with ffmpeg once the order is 
+complete, using an $1[[encode]]$0 from $1config.toml$0.
+
+$1Priority$0
+--------
+
+    $1--priority 10$0
+
+Sets the priority of the order.  The queue is sorted by 
+priority, highest first, and then by age.  Orders default to a 
+priority of zero.
+
+$1After$0
+-----
+
+    $1--after name[,name]$0
+
+Makes the order wait for one or more other orders to complete 
+before it will render.  If any of them fail, this order is 
+blocked.
 `
 		case "redo":
 			return `
-Redo resets an order's completion status so it can be run 
-again, without rebuilding or changing the order's files (if 
-they are cached, for example).
+Redo resets an order's completion status and progress so it can 
+be run again from the start, without rebuilding or changing the 
+order's files (if they are cached, for example).
 
 $1Redo Usage$0
 ----------
 
     $1redo [name]$0
+    $1redo [name] --frames 5-7,12$0
+
+Giving frames renders just those of the order's frames again, 
+overwriting them whatever the order's overwrite setting.  The 
+order keeps its own frames and overwrite setting, and goes back 
+to rendering all of them when it's next redone without frames.  
+Markers can't be used here.
+`
+		case "release":
+			return `
+Release allows held orders to be rendered again.
+
+$1Release Usage$0
+-------------
+
+    $1release [name...]$0
 `
 		case "render":
 			return `
 Render starts Sous Chef's queue and renders any jobs listed 
-therein.
+therein.  Held orders are skipped, as are orders still waiting 
+on their dependencies.
 
 $1Render Usage$0
 ------------
 
-    $1render$0
+    $1render [--flags]$0
+
+Progress is recorded as each frame is saved, so an interrupted 
+order will resume from the frame after the last one that was 
+completed.  The progress line shows an ETA for the order and 
+the whole queue, based on how long its frames have taken.
+
+If Blender fails, the error is recorded in the order.  
+Depending on the project's retry policy in $1config.toml$0, the 
+order may be retried automatically, resuming from its last 
+completed frame.  Otherwise, it's marked as failed and skipped 
+until it is redone or retried.
+
+Any $1[hooks]$0 in $1config.toml$0 are run as the queue starts 
+and drains, and before and after each order.  See the readme 
+for details.
+
+$1Retry Failed$0
+------------
+
+    $1--retry-failed$0
+
+Also attempts any failed orders, resuming them from their last 
+completed frame.
+`
+		case "serve":
+			return `
+Serve runs a local HTTP server with a JSON API for listing, 
+creating, redoing, deleting, holding, releasing and moving 
+orders, as well as a dashboard for keeping an eye on the queue 
+from a browser.  It works on the same orders directory as the 
+CLI, so both can be used at once.
+
+$1Serve Usage$0
+-----------
+
+    $1serve [--addr 127.0.0.1:8421]$0
+
+$1Endpoints$0
+---------
+
+    $1GET$0     /api/orders
+    $1POST$0    /api/orders
+    $1GET$0     /api/orders/NAME
+    $1DELETE$0  /api/orders/NAME
+    $1POST$0    /api/orders/NAME/redo
+    $1POST$0    /api/orders/NAME/hold
+    $1POST$0    /api/orders/NAME/release
+    $1POST$0    /api/orders/NAME/move
+    $1GET$0     /api/orders/NAME/log
+
+The dashboard is at the server's address itself, for example 
+$1http://127.0.0.1:8421$0.
+
+Every POST and DELETE must be sent with $1Content-Type: 
+application/json$0, even without a body.  Orders that are still 
+being rendered can't be deleted.
+
+See the readme for the request and response formats.  There is 
+no authentication: only listen on addresses you trust.
+`
+		case "stats":
+			return `
+Stats summarises the render time, peak memory and samples of 
+every frame, which are recorded as each frame is saved.
+
+$1Stats Usage$0
+-----------
+
+    $1stats [name]$0
+
+For each order, stats shows the fastest, median and slowest 
+frame times, the slowest frames, the highest peak memory, the 
+ETA of anything left and the render time each machine has spent 
+on it.
+
+Without a name, every order that has rendered anything is 
+shown, followed by the machine time of the whole project and 
+the ETA of the queue.
+
+Frames rendered more than once only count their latest render 
+towards frame times, but every render counts towards machine 
+time.
+
+$1Output$0
+------
+
+    $1--json$0
+    $1--toml$0
+
+Prints the same information in a machine-readable form.  See 
+the readme for the schema.
+`
+		case "status":
+			return `
+Status prints a table of every order in the queue, with its 
+state, the machines rendering it, their current frames, the 
+percentage complete, elapsed time and an estimate of the time 
+remaining.
+
+The information comes from the lock files kept up to date by 
+each rendering machine, so status can be run from any machine 
+with access to the project.
+
+$1Status Usage$0
+------------
+
+    $1status$0
+    $1status --watch$0
+
+$1--watch -w$0 keeps the table refreshing in place until 
+interrupted.  If the output isn't a terminal, each table is 
+printed after the last.
+
+$1Output$0
+------
+
+    $1--json$0
+    $1--toml$0
+
+Prints the same information in a machine-readable form.  See 
+the readme for the schema.
 `
 		case "targets":
 			return `
@@ -239,6 +712,43 @@ $1Target Usage$0
 ------------
 
     $1targets$0
+
+$1Output$0
+------
+
+    $1--json$0
+    $1--toml$0
+
+Prints the same information in a machine-readable form.  See 
+the readme for the schema.
+`
+		case "verify":
+			return `
+Verify checks that every frame of an order was actually 
+rendered, reporting frames that are missing, empty or, for PNG 
+and JPEG, can't be decoded.
+
+Blender is asked for the exact output filenames, so the scene's 
+output path and the paths of any File Output nodes are all 
+checked, including after Sous Chef has redirected them.
+
+$1Verify Usage$0
+------------
+
+    $1verify [name]$0
+    $1verify [name] --redo$0
+
+$1--redo$0 requeues the order with only the bad frames, as with 
+$1redo --frames$0.
+
+$1Output$0
+------
+
+    $1--json$0
+    $1--toml$0
+
+Prints the same information in a machine-readable form.  See 
+the readme for the schema.
 `
 	}
 	return help("help")
@@ -18,8 +18,21 @@
 
 package main
 
+import "syscall"
+
 const OS_CONFIG_PATH = SOUS_DIR + "/config_linux.toml"
 
+// hooks are run through the system shell
+const HOOK_SHELL      = "/bin/sh"
+const HOOK_SHELL_FLAG = "-c"
+
+// signal 0 checks the process is there without touching
+// it; EPERM means it exists but belongs to someone else
+func process_exists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
 const config_file = `# the version to use by default when creating
 # a new order
 default_target = "4.2"
@@ -37,4 +50,20 @@ path = "~/software/blender_4.2/blender"
 
 [[target]]
 name = "canary"
-path = "~/dev/buildbot/blender`
+path = "~/dev/buildbot/blender"
+
+# presets bundle order settings under a label
+# that can be chosen with --preset.  every field
+# is optional and explicit flags always win
+[[preset]]
+name = "previz"
+resolution = "hd"
+percentage = 50
+samples = 16
+placeholders = "yes"
+overwrite = "no"
+
+# hooks run shell commands around the queue,
+# with the order's details in SOUS_* variables
+# [hooks]
+# order_success = "rsync -a \"$SOUS_OUTPUT/\" render-server:/renders/"`
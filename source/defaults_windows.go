@@ -18,8 +18,36 @@
 
 package main
 
+import "syscall"
+
 const OS_CONFIG_PATH = SOUS_DIR + "/config_windows.toml"
 
+// hooks are run through the system shell
+const HOOK_SHELL      = "cmd.exe"
+const HOOK_SHELL_FLAG = "/C"
+
+// neither of these are in the syscall package
+const PROCESS_QUERY_LIMITED_INFORMATION = 0x1000
+const STILL_ACTIVE                      = 259
+
+// a process that has exited can still be opened while
+// anything holds a handle to it, so it's the exit code
+// that says whether it's really still running
+func process_exists(pid int) bool {
+	handle, err := syscall.OpenProcess(PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// it's there, it just belongs to someone else
+		return err == syscall.ERROR_ACCESS_DENIED
+	}
+	defer syscall.CloseHandle(handle)
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(handle, &code); err != nil {
+		return true
+	}
+	return code == STILL_ACTIVE
+}
+
 const config_file = `# the version to use by default when creating
 # a new order
 default_target = "4.2"
@@ -37,4 +65,20 @@ path = "C:/Program Files/Blender Foundation/Blender 4.2/blender.exe"
 
 [[target]]
 name = "canary"
-path = "X:/development/buildbot/blender.exe"`
+path = "X:/development/buildbot/blender.exe"
+
+# presets bundle order settings under a label
+# that can be chosen with --preset.  every field
+# is optional and explicit flags always win
+[[preset]]
+name = "previz"
+resolution = "hd"
+percentage = 50
+samples = 16
+placeholders = "yes"
+overwrite = "no"
+
+# hooks run shell commands around the queue,
+# with the order's details in SOUS_* variables
+# [hooks]
+# order_success = "robocopy \"%SOUS_OUTPUT%\" \\\\render-server\\renders /E"`
@@ -0,0 +1,146 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "strings"
+
+const (
+	DEPENDENCIES_READY uint8 = iota
+	DEPENDENCIES_WAITING
+	DEPENDENCIES_BLOCKED
+)
+
+func find_order(queue Order_Array, name string) (*Order, bool) {
+	for _, order := range queue {
+		if order.Name == name {
+			return order, true
+		}
+	}
+	return nil, false
+}
+
+// an order is blocked if anything up its dependency
+// chain has failed, and waiting if anything up the
+// chain is yet to finish.  dependencies that have
+// gone missing are assumed to have been cleaned
+// away after completing
+func dependency_state(order *Order, queue Order_Array) uint8 {
+	return dependency_walk(order, queue, make(map[string]bool, 8))
+}
+
+func dependency_walk(order *Order, queue Order_Array, seen map[string]bool) uint8 {
+	state := DEPENDENCIES_READY
+
+	for _, name := range order.After {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		dep, ok := find_order(queue, name)
+		if !ok {
+			continue
+		}
+
+		if dep.Status == STATUS_FAILED {
+			return DEPENDENCIES_BLOCKED
+		}
+
+		switch dependency_walk(dep, queue, seen) {
+		case DEPENDENCIES_BLOCKED:
+			return DEPENDENCIES_BLOCKED
+		case DEPENDENCIES_WAITING:
+			state = DEPENDENCIES_WAITING
+		}
+
+		if dep.Status != STATUS_COMPLETE {
+			state = DEPENDENCIES_WAITING
+		}
+	}
+
+	return state
+}
+
+// checks that a new (or replacement) order only depends
+// on orders that exist and doesn't create any cycles
+func check_dependencies(order *Order, queue Order_Array) bool {
+	for _, name := range order.After {
+		if name == order.Name {
+			eprintf(apply_color("Order $1%q$0 cannot depend on itself\n"), name)
+			return false
+		}
+
+		if _, ok := find_order(queue, name); !ok {
+			eprintf(apply_color("Dependency $1%q$0 does not exist\n"), name)
+			return false
+		}
+	}
+
+	// the replacement stands in for any old copy
+	graph := make(map[string][]string, len(queue) + 1)
+	for _, other := range queue {
+		graph[other.Name] = other.After
+	}
+	graph[order.Name] = order.After
+
+	path := make([]string, 0, 8)
+
+	var visit func(name string) bool
+	visit = func(name string) bool {
+		for i, step := range path {
+			if step == name {
+				cycle := append(path[i:], name)
+				eprintf("Dependency cycle: %s\n", strings.Join(cycle, " -> "))
+				return false
+			}
+		}
+
+		path = append(path, name)
+		defer func() { path = path[:len(path) - 1] }()
+
+		for _, next := range graph[name] {
+			if !visit(next) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return visit(order.Name)
+}
+
+// prints the dependency chain in list, indented
+// under each of the orders that depend on it
+func print_dependencies(order *Order, queue Order_Array, depth int) {
+	for _, name := range order.After {
+		indent := strings.Repeat("  ", depth)
+
+		dep, ok := find_order(queue, name)
+		if !ok {
+			printf("   %-14s%s%s (removed)\n", "", indent, name)
+			continue
+		}
+
+		printf("   %-14s%s%s (%s)\n", "", indent, name, dep.Status.String())
+
+		if depth < 8 {
+			print_dependencies(dep, queue, depth + 1)
+		}
+	}
+}
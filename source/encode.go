@@ -0,0 +1,306 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "fmt"
+import "time"
+import "errors"
+import "strings"
+import "os/exec"
+import "path/filepath"
+
+const DEFAULT_FFMPEG        = "ffmpeg"
+const DEFAULT_VIDEO_CODEC   = "h264"
+const DEFAULT_ENCODE_OUTPUT = "{output}/{order}{ext}"
+const ENCODE_LIST_NAME      = "encode.ffconcat"
+
+const (
+	ENCODE_COMPLETE = "complete"
+	ENCODE_FAILED   = "failed"
+)
+
+// an [[encode]] section in config.toml, which is copied
+// into each order that asks for it, so that editing the
+// config doesn't change orders already in the queue
+type Encode_Spec struct {
+	Name   string   `toml:"name"`
+	Codec  string   `toml:"codec"`
+	FPS    float64  `toml:"fps"`
+	Output string   `toml:"output"`
+	Args   []string `toml:"args"`
+}
+
+// the outcome of the last encode, kept apart from the
+// render's own error fields: a movie that won't encode
+// doesn't mean the frames themselves are bad
+type Encode_Result struct {
+	Status string    `toml:"status"`
+	Path   string    `toml:"path"`
+	Error  string    `toml:"error"`
+	Host   string    `toml:"host"`
+	Time   time.Time `toml:"time"`
+}
+
+type Video_Codec struct {
+	name string
+	ext  string
+	args []string
+}
+
+// codecs with settings that make sense for review
+// copies; anything else is handed to ffmpeg as-is
+var video_codecs = []*Video_Codec{
+	{"h264",       ".mp4",  []string{"-c:v", "libx264", "-pix_fmt", "yuv420p", "-crf", "18", "-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2"}},
+	{"h265",       ".mp4",  []string{"-c:v", "libx265", "-pix_fmt", "yuv420p", "-crf", "22", "-tag:v", "hvc1", "-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2"}},
+	{"prores",     ".mov",  []string{"-c:v", "prores_ks", "-profile:v", "3", "-pix_fmt", "yuv422p10le"}},
+	{"prores4444", ".mov",  []string{"-c:v", "prores_ks", "-profile:v", "4", "-pix_fmt", "yuva444p10le"}},
+	{"dnxhr",      ".mov",  []string{"-c:v", "dnxhd", "-profile:v", "dnxhr_hq", "-pix_fmt", "yuv422p"}},
+	{"vp9",        ".webm", []string{"-c:v", "libvpx-vp9", "-crf", "30", "-b:v", "0", "-pix_fmt", "yuv420p"}},
+}
+
+func get_video_codec(name string) *Video_Codec {
+	if name == "" {
+		name = DEFAULT_VIDEO_CODEC
+	}
+
+	for _, codec := range video_codecs {
+		if strings.EqualFold(codec.name, name) {
+			return codec
+		}
+	}
+
+	return &Video_Codec{name, ".mkv", []string{"-c:v", name}}
+}
+
+func get_encode(config *Config, name string) (*Encode_Spec, bool) {
+	for _, spec := range config.Encode {
+		if strings.EqualFold(spec.Name, name) {
+			return spec, true
+		}
+	}
+	return nil, false
+}
+
+func apply_encode(config *Config, order *Order, name string) bool {
+	if name == "" {
+		return true
+	}
+
+	spec, ok := get_encode(config, name)
+	if !ok {
+		eprintf(apply_color("Encode $1%q$0 not in config.toml\n"), name)
+		return false
+	}
+
+	spec_copy   := *spec
+	order.Encode = &spec_copy
+
+	return true
+}
+
+func ffmpeg_path(config *Config) string {
+	if config.FFmpeg == "" {
+		return DEFAULT_FFMPEG
+	}
+	return config.FFmpeg
+}
+
+func encode_path(config *Config, order *Order, codec *Video_Codec) string {
+	template := order.Encode.Output
+	if template == "" {
+		template = DEFAULT_ENCODE_OUTPUT
+	}
+
+	template = strings.NewReplacer(
+		"{name}",  order.Encode.Name,
+		"{codec}", codec.name,
+		"{ext}",   codec.ext,
+	).Replace(template)
+
+	return order_output_path(config, order, template)
+}
+
+// fills in an output path template, such as for an encode
+// or contact sheet, relative to the project if it isn't
+// already absolute
+func order_output_path(config *Config, order *Order, template string) string {
+	source := filepath.Base(order.Source_Path)
+	source  = strings.TrimSuffix(source, filepath.Ext(source))
+
+	path := strings.NewReplacer(
+		"{order}",   order.Name,
+		"{scene}",   order.Scene,
+		"{source}",  source,
+		"{output}",  filepath.Join(config.project_dir, filepath.FromSlash(order.Output_Path)),
+		"{project}", config.project_dir,
+	).Replace(template)
+
+	path = filepath.FromSlash(path)
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(config.project_dir, path)
+	}
+
+	return filepath.Clean(path)
+}
+
+// runs the order's encode and records the outcome in
+// its manifest, whether or not it worked
+func encode_order(config *Config, order *Order) bool {
+	printf(apply_color("[$1%s$0] encoding %s..."), order.Name, order.Encode.Name)
+
+	path, err := run_encode(config, order)
+
+	order.Encoded = &Encode_Result{
+		Status: ENCODE_COMPLETE,
+		Path:   path,
+		Host:   config.own_hostname,
+		Time:   time.Now(),
+	}
+
+	if err != nil {
+		order.Encoded.Status = ENCODE_FAILED
+		order.Encoded.Error  = err.Error()
+	}
+
+	printf(RESET_LINE)
+
+	update_order(config.project_dir, order.Name, func(current *Order) {
+		current.Encoded = order.Encoded
+	})
+
+	if err != nil {
+		eprintf(apply_color("[$1%s$0] encode failed: %s\n"), order.Name, err.Error())
+		notify(config, encode_notice(config, order))
+		return false
+	}
+
+	printf(apply_color("[$1%s$0] encoded %s ✓\n"), order.Name, path)
+	return true
+}
+
+func run_encode(config *Config, order *Order) (string, error) {
+	codec := get_video_codec(order.Encode.Codec)
+	path  := encode_path(config, order, codec)
+
+	fps := order.Encode.FPS
+	if fps <= 0 {
+		fps = order.Frame_Rate
+	}
+	if fps <= 0 {
+		return path, errors.New("the scene's frame rate is unknown: set fps in the encode")
+	}
+
+	outputs, ok := verify_outputs(config, order)
+	if !ok {
+		return path, errors.New("failed to gather outputs from " + filepath.Base(order.Source_Path))
+	}
+
+	// only the scene's own output is encoded,
+	// not any of its File Output nodes
+	output := outputs[0]
+
+	if bad := len(output.Missing) + len(output.Empty) + len(output.Broken); bad > 0 {
+		return path, fmt.Errorf("%d frames are missing or broken; see verify", bad)
+	}
+
+	if len(output.Files) == 0 {
+		return path, errors.New("the order has no frames")
+	}
+
+	// the concat demuxer copes with gaps in the numbering
+	// from steps and frame lists, which image2 can't do
+	list := strings.Builder{}
+	list.WriteString("ffconcat version 1.0\n")
+
+	for _, file := range output.Files {
+		fmt.Fprintf(&list, "file %s\nduration %g\n", concat_quote(file), 1 / fps)
+	}
+
+	// the last file is repeated, otherwise
+	// its duration is ignored by ffmpeg
+	fmt.Fprintf(&list, "file %s\n", concat_quote(output.Files[len(output.Files) - 1]))
+
+	list_path := filepath.Join(order_path(config.project_dir, order.Name), ENCODE_LIST_NAME)
+
+	if !write_file(list_path, list.String()) {
+		return path, errors.New("failed to write " + list_path)
+	}
+
+	if !make_directory(filepath.Dir(path)) {
+		return path, errors.New("failed to create " + filepath.Dir(path))
+	}
+
+	arguments := []string{
+		"-y", "-hide_banner", "-loglevel", "error",
+		"-f", "concat", "-safe", "0", "-i", list_path,
+		"-r", fmt.Sprintf("%g", fps),
+	}
+
+	arguments = append(arguments, codec.args...)
+	arguments = append(arguments, order.Encode.Args...)
+	arguments = append(arguments, path)
+
+	blob, err := exec.Command(ffmpeg_path(config), arguments...).CombinedOutput()
+	if err != nil {
+		// ffmpeg puts the useful part last
+		lines := strings.Split(strings.TrimSpace(string(blob)), "\n")
+		if last := strings.TrimSpace(lines[len(lines) - 1]); last != "" {
+			return path, errors.New(last)
+		}
+		return path, err
+	}
+
+	return path, nil
+}
+
+func concat_quote(path string) string {
+	return "'" + strings.ReplaceAll(filepath.ToSlash(path), "'", `'\''`) + "'"
+}
+
+// runs the encode again by hand, for orders that
+// failed to encode or that didn't ask for one
+func command_encode(config *Config, args *Arguments) {
+	queue, ok := load_orders(config.project_dir, false)
+	if !ok {
+		return
+	}
+
+	order, ok := find_order(queue, args.source_path)
+	if !ok {
+		eprintf(apply_color("Order $1%q$0 does not exist\n"), args.source_path)
+		return
+	}
+
+	if order.Status != STATUS_COMPLETE {
+		eprintf(apply_color("[$1%s$0] hasn't finished rendering\n"), order.Name)
+		return
+	}
+
+	if !apply_encode(config, order, args.encode) {
+		return
+	}
+
+	if order.Encode == nil {
+		eprintf(apply_color("[$1%s$0] has no encode: choose one with --encode\n"), order.Name)
+		return
+	}
+
+	encode_order(config, order)
+}
@@ -0,0 +1,500 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "os"
+import "sort"
+import "time"
+import "encoding/json"
+import "github.com/BurntSushi/toml"
+
+// bump this whenever a field is renamed, removed or
+// changes meaning.  new fields don't require a bump
+const SCHEMA_VERSION = 1
+
+const (
+	OUTPUT_TEXT uint8 = iota
+	OUTPUT_JSON
+	OUTPUT_TOML
+)
+
+// the exported documents are deliberately separate from
+// the manifests, so the manifests can keep changing
+// without breaking anyone's scripts.  TOML has no null,
+// so anything that would be null in JSON is left out
+type Export_Queue struct {
+	Schema_Version int             `json:"schema_version" toml:"schema_version"`
+	Generated      time.Time       `json:"generated"      toml:"generated"`
+	Orders         []*Export_Order `json:"orders"         toml:"orders"`
+}
+
+type Export_Order struct {
+	Name           string    `json:"name"           toml:"name"`
+	State          string    `json:"state"          toml:"state"`
+	Status         string    `json:"status"         toml:"status"`
+	Held           bool      `json:"held"           toml:"held"`
+	Priority       int       `json:"priority"       toml:"priority"`
+	After          []string  `json:"after"          toml:"after"`
+	Created        time.Time `json:"created"        toml:"created"`
+	Preset         string    `json:"preset"         toml:"preset"`
+	Blender_Target string    `json:"blender_target" toml:"blender_target"`
+	Source_Path    string    `json:"source_path"    toml:"source_path"`
+	Target_Path    string    `json:"target_path"    toml:"target_path"`
+	Output_Path    string    `json:"output_path"    toml:"output_path"`
+	Cached         bool      `json:"cached"         toml:"cached"`
+	Scene          string    `json:"scene"          toml:"scene"`
+	View_Layer     string    `json:"view_layer"     toml:"view_layer"`
+	Camera         string    `json:"camera"         toml:"camera"`
+
+	Start_Frame int    `json:"start_frame" toml:"start_frame"`
+	End_Frame   int    `json:"end_frame"   toml:"end_frame"`
+	Frame_Step  uint   `json:"frame_step"  toml:"frame_step"`
+	Frames      string `json:"frames"      toml:"frames"`
+	Redo_Frames string `json:"redo_frames" toml:"redo_frames"`
+	Frame_Count int    `json:"frame_count" toml:"frame_count"`
+	Chunk_Size  uint   `json:"chunk_size"  toml:"chunk_size"`
+
+	Resolution_X       uint    `json:"resolution_x"       toml:"resolution_x"`
+	Resolution_Y       uint    `json:"resolution_y"       toml:"resolution_y"`
+	File_Format        string  `json:"file_format"        toml:"file_format"`
+	Color_Depth        string  `json:"color_depth"        toml:"color_depth"`
+	Codec              string  `json:"codec"              toml:"codec"`
+	Engine             string  `json:"engine"             toml:"engine"`
+	Samples            uint    `json:"samples"            toml:"samples"`
+	Adaptive_Threshold float64 `json:"adaptive_threshold" toml:"adaptive_threshold"`
+	Denoise            string  `json:"denoise"            toml:"denoise"`
+	Light_Bounces      *uint   `json:"light_bounces"      toml:"light_bounces,omitempty"`
+	Simplify           *uint   `json:"simplify"           toml:"simplify,omitempty"`
+	Motion_Blur        string  `json:"motion_blur"        toml:"motion_blur"`
+	Placeholders       string  `json:"placeholders"       toml:"placeholders"`
+	Overwrite          string  `json:"overwrite"          toml:"overwrite"`
+
+	Retry    *Export_Retry    `json:"retry"    toml:"retry,omitempty"`
+	Attempts uint             `json:"attempts" toml:"attempts"`
+	Error    *Export_Error    `json:"error"    toml:"error,omitempty"`
+	Encode   *Export_Encode   `json:"encode"   toml:"encode,omitempty"`
+	Progress *Export_Progress `json:"progress" toml:"progress"`
+	Locks    []*Export_Lock   `json:"locks"    toml:"locks"`
+}
+
+type Export_Retry struct {
+	Attempts        uint    `json:"attempts"        toml:"attempts"`
+	Delay           uint    `json:"delay"           toml:"delay"`
+	Backoff         float64 `json:"backoff"         toml:"backoff"`
+	No_Render_Cache bool    `json:"no_render_cache" toml:"no_render_cache"`
+}
+
+type Export_Encode struct {
+	Name   string     `json:"name"   toml:"name"`
+	Codec  string     `json:"codec"  toml:"codec"`
+	Status string     `json:"status" toml:"status"`
+	Path   string     `json:"path"   toml:"path"`
+	Error  string     `json:"error"  toml:"error"`
+	Time   *time.Time `json:"time"   toml:"time,omitempty"`
+}
+
+type Export_Error struct {
+	Kind      string `json:"kind"      toml:"kind"`
+	Message   string `json:"message"   toml:"message"`
+	Exit_Code int    `json:"exit_code" toml:"exit_code"`
+	Frame     *int   `json:"frame"     toml:"frame,omitempty"`
+}
+
+type Export_Progress struct {
+	Done            int      `json:"done"             toml:"done"`
+	Total           int      `json:"total"            toml:"total"`
+	Percentage      int      `json:"percentage"       toml:"percentage"`
+	Resume_Frame    *int     `json:"resume_frame"     toml:"resume_frame,omitempty"`
+	Chunks_Complete *int     `json:"chunks_complete"  toml:"chunks_complete,omitempty"`
+	Chunks_Total    *int     `json:"chunks_total"     toml:"chunks_total,omitempty"`
+	ETA_Seconds     *float64 `json:"eta_seconds"      toml:"eta_seconds,omitempty"`
+}
+
+type Export_Lock struct {
+	Order       string    `json:"order"       toml:"order"`
+	Chunk       *int      `json:"chunk"       toml:"chunk,omitempty"`
+	Host        string    `json:"host"        toml:"host"`
+	PID         int       `json:"pid"         toml:"pid"`
+	Started     time.Time `json:"started"     toml:"started"`
+	Heartbeat   time.Time `json:"heartbeat"   toml:"heartbeat"`
+	Stale       bool      `json:"stale"       toml:"stale"`
+	Frame       *int      `json:"frame"       toml:"frame,omitempty"`
+	Done        uint      `json:"done"        toml:"done"`
+	Total       uint      `json:"total"       toml:"total"`
+}
+
+type Export_Locks struct {
+	Schema_Version int            `json:"schema_version" toml:"schema_version"`
+	Locks          []*Export_Lock `json:"locks"          toml:"locks"`
+}
+
+type Export_Targets struct {
+	Schema_Version int              `json:"schema_version" toml:"schema_version"`
+	Default_Target string           `json:"default_target" toml:"default_target"`
+	Targets        []*Export_Target `json:"targets"        toml:"targets"`
+}
+
+type Export_Target struct {
+	Name   string `json:"name"   toml:"name"`
+	Path   string `json:"path"   toml:"path"`
+	Exists bool   `json:"exists" toml:"exists"`
+}
+
+type Export_Verify struct {
+	Schema_Version int              `json:"schema_version" toml:"schema_version"`
+	Order          string           `json:"order"          toml:"order"`
+	Outputs        []*Export_Output `json:"outputs"        toml:"outputs"`
+	Requeued       []int            `json:"requeued"       toml:"requeued"`
+}
+
+type Export_Output struct {
+	Pattern string `json:"pattern" toml:"pattern"`
+	Missing []int  `json:"missing" toml:"missing"`
+	Empty   []int  `json:"empty"   toml:"empty"`
+	Broken  []int  `json:"broken"  toml:"broken"`
+}
+
+type Export_Stats struct {
+	Schema_Version int                   `json:"schema_version" toml:"schema_version"`
+	Orders         []*Export_Order_Stats `json:"orders"         toml:"orders"`
+	ETA_Seconds    *float64              `json:"eta_seconds"    toml:"eta_seconds,omitempty"`
+	Hosts          []*Export_Host_Stats  `json:"hosts"          toml:"hosts"`
+}
+
+type Export_Order_Stats struct {
+	Order          string               `json:"order"          toml:"order"`
+	Rendered       int                  `json:"rendered"       toml:"rendered"`
+	Total          int                  `json:"total"          toml:"total"`
+	Min_Seconds    float64              `json:"min_seconds"    toml:"min_seconds"`
+	Median_Seconds float64              `json:"median_seconds" toml:"median_seconds"`
+	Max_Seconds    float64              `json:"max_seconds"    toml:"max_seconds"`
+	ETA_Seconds    *float64             `json:"eta_seconds"    toml:"eta_seconds,omitempty"`
+	Hosts          []*Export_Host_Stats `json:"hosts"          toml:"hosts"`
+	Frames         []*Export_Frame_Stat `json:"frames"         toml:"frames"`
+}
+
+type Export_Host_Stats struct {
+	Host    string  `json:"host"    toml:"host"`
+	Seconds float64 `json:"seconds" toml:"seconds"`
+	Frames  int     `json:"frames"  toml:"frames"`
+}
+
+// only the latest render of each frame
+type Export_Frame_Stat struct {
+	Frame        int       `json:"frame"        toml:"frame"`
+	Host         string    `json:"host"         toml:"host"`
+	Time         time.Time `json:"time"         toml:"time"`
+	Seconds      float64   `json:"seconds"      toml:"seconds"`
+	Peak_Memory  float64   `json:"peak_memory"  toml:"peak_memory"`
+	Samples      uint      `json:"samples"      toml:"samples"`
+	Sample_Total uint      `json:"sample_total" toml:"sample_total"`
+}
+
+func write_data(format uint8, data any) bool {
+	var err error
+
+	switch format {
+	case OUTPUT_JSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "\t")
+		err = encoder.Encode(data)
+	case OUTPUT_TOML:
+		err = toml.NewEncoder(os.Stdout).Encode(data)
+	}
+
+	if err != nil {
+		eprintln("Failed to encode output:", err.Error())
+		return false
+	}
+	return true
+}
+
+func yes_no(value uint8) string {
+	switch value {
+	case YES:
+		return "yes"
+	case NO:
+		return "no"
+	}
+	return ""
+}
+
+func export_queue(config *Config, queue Order_Array) *Export_Queue {
+	data := &Export_Queue{
+		Schema_Version: SCHEMA_VERSION,
+		Generated:      time.Now(),
+		Orders:         make([]*Export_Order, 0, len(queue)),
+	}
+
+	for _, order := range queue {
+		data.Orders = append(data.Orders, export_order(config, order, queue))
+	}
+
+	return data
+}
+
+func export_order(config *Config, order *Order, queue Order_Array) *Export_Order {
+	data := &Export_Order{
+		Name:           order.Name,
+		State:          order_state(config, order, queue),
+		Status:         order.Status.String(),
+		Held:           order.Held,
+		Priority:       order.Priority,
+		After:          order.After,
+		Created:        order.Time,
+		Preset:         order.Preset,
+		Blender_Target: order.Blender_Target,
+		Source_Path:    order.Source_Path,
+		Target_Path:    order.Target_Path,
+		Output_Path:    order.Output_Path,
+		Cached:         order.Target_Path != order.Source_Path,
+		Scene:          order.Scene,
+		View_Layer:     order.View_Layer,
+		Camera:         order.Camera,
+
+		Start_Frame: order.Start_Frame,
+		End_Frame:   order.End_Frame,
+		Frame_Step:  order.Frame_Step,
+		Frames:      order.Frames,
+		Redo_Frames: order.Redo_Frames,
+		Frame_Count: len(order.frame_list()),
+		Chunk_Size:  order.Chunk_Size,
+
+		Resolution_X:       order.Resolution_X,
+		Resolution_Y:       order.Resolution_Y,
+		File_Format:        order.File_Format,
+		Color_Depth:        order.Color_Depth,
+		Codec:              order.Codec,
+		Engine:             order.Engine,
+		Samples:            order.Samples,
+		Adaptive_Threshold: order.Adaptive_Threshold,
+		Denoise:            yes_no(order.Denoise),
+		Light_Bounces:      order.Light_Bounces,
+		Simplify:           order.Simplify,
+		Motion_Blur:        yes_no(order.Motion_Blur),
+		Placeholders:       yes_no(order.Use_Placeholders),
+		Overwrite:          yes_no(order.Overwrite),
+
+		Attempts: order.Attempts,
+		Locks:    export_locks(config, order),
+	}
+
+	if data.After == nil {
+		data.After = []string{}
+	}
+
+	if order.Output_Path == "." {
+		data.Output_Path = ""
+	}
+
+	// a failed order reports the policy for the kind
+	// of error it failed with, as that's what applies
+	if order.Retry != nil {
+		policy := retry_policy(config, order, order.Error_Kind)
+
+		data.Retry = &Export_Retry{
+			Attempts:        policy.Attempts,
+			Delay:           policy.Delay,
+			Backoff:         policy.Backoff,
+			No_Render_Cache: policy.No_Render_Cache,
+		}
+	}
+
+	if order.Status == STATUS_FAILED {
+		data.Error = &Export_Error{
+			Kind:      order.Error_Kind.Name(),
+			Message:   order.Error_Message,
+			Exit_Code: order.Exit_Code,
+			Frame:     order.Error_Frame,
+		}
+	}
+
+	if order.Encode != nil {
+		data.Encode = &Export_Encode{
+			Name:   order.Encode.Name,
+			Codec:  get_video_codec(order.Encode.Codec).name,
+			Status: "pending",
+		}
+
+		if order.Encoded != nil {
+			data.Encode.Status = order.Encoded.Status
+			data.Encode.Path   = order.Encoded.Path
+			data.Encode.Error  = order.Encoded.Error
+			data.Encode.Time   = &order.Encoded.Time
+		}
+	}
+
+	progress := order_progress(config, order)
+
+	data.Progress = &Export_Progress{
+		Done:       progress.Done,
+		Total:      progress.Total,
+		Percentage: progress.percentage(),
+	}
+
+	if d, ok := progress.eta(); ok {
+		seconds := d.Seconds()
+		data.Progress.ETA_Seconds = &seconds
+	}
+
+	if order.Chunk_Size > 0 {
+		chunks   := load_chunks(config.project_dir, order)
+		complete := count_complete_chunks(chunks)
+		total    := len(chunks)
+
+		data.Progress.Chunks_Complete = &complete
+		data.Progress.Chunks_Total    = &total
+	} else if order.Has_Progress && order.Status != STATUS_COMPLETE {
+		resume := order.resume_frame()
+		data.Progress.Resume_Frame = &resume
+	}
+
+	return data
+}
+
+func export_lock(config *Config, name string, chunk *Chunk, lock *Lock) *Export_Lock {
+	data := &Export_Lock{
+		Order:     name,
+		Host:      lock.Host,
+		PID:       lock.PID,
+		Started:   lock.Started,
+		Heartbeat: lock.Heartbeat,
+		Stale:     lock.is_stale(config),
+		Done:      lock.Done,
+		Total:     lock.Total,
+	}
+
+	if chunk != nil {
+		index := chunk.index + 1
+		data.Chunk = &index
+	}
+
+	if !lock.Run_Started.IsZero() {
+		frame := lock.Frame
+		data.Frame = &frame
+	}
+
+	return data
+}
+
+func export_locks(config *Config, order *Order) []*Export_Lock {
+	list := make([]*Export_Lock, 0, 1)
+
+	if order.lock != nil {
+		list = append(list, export_lock(config, order.Name, nil, order.lock))
+	}
+
+	if order.Chunk_Size > 0 {
+		for _, chunk := range load_chunks(config.project_dir, order) {
+			if chunk.lock != nil {
+				list = append(list, export_lock(config, order.Name, chunk, chunk.lock))
+			}
+		}
+	}
+
+	return list
+}
+
+// empty lists come out as [] rather than null
+func non_nil(list []int) []int {
+	if list == nil {
+		return []int{}
+	}
+	return list
+}
+
+func export_stats(config *Config, queue Order_Array, estimate *Queue_Estimate) *Export_Stats {
+	data := &Export_Stats{
+		Schema_Version: SCHEMA_VERSION,
+		Orders:         make([]*Export_Order_Stats, 0, len(queue)),
+	}
+
+	everything := make([]*Frame_Stat, 0, 256)
+
+	for _, order := range queue {
+		list   := load_stats(config.project_dir, order.Name)
+		latest := latest_stats(list)
+
+		everything = append(everything, list...)
+
+		entry := &Export_Order_Stats{
+			Order:    order.Name,
+			Rendered: len(latest),
+			Total:    len(order.frame_list()),
+			Hosts:    export_host_stats(list),
+			Frames:   make([]*Export_Frame_Stat, len(latest)),
+		}
+
+		times := make([]float64, len(latest))
+
+		for i, stat := range latest {
+			times[i] = stat.Seconds
+
+			entry.Frames[i] = &Export_Frame_Stat{
+				Frame:        stat.Frame,
+				Host:         stat.Host,
+				Time:         stat.Time,
+				Seconds:      stat.Seconds,
+				Peak_Memory:  stat.Peak_Memory,
+				Samples:      stat.Samples,
+				Sample_Total: stat.Sample_Total,
+			}
+		}
+
+		if len(times) > 0 {
+			sort.Float64s(times)
+			entry.Min_Seconds    = times[0]
+			entry.Median_Seconds = median(times)
+			entry.Max_Seconds    = times[len(times) - 1]
+		}
+
+		if progress, ok := estimate.progress[order.Name]; ok {
+			if d, ok := progress.eta(); ok {
+				seconds := d.Seconds()
+				entry.ETA_Seconds = &seconds
+			}
+		}
+
+		data.Orders = append(data.Orders, entry)
+	}
+
+	data.Hosts = export_host_stats(everything)
+
+	if d, ok := estimate.eta(); ok {
+		seconds := d.Seconds()
+		data.ETA_Seconds = &seconds
+	}
+
+	return data
+}
+
+func export_host_stats(list []*Frame_Stat) []*Export_Host_Stats {
+	hosts := host_stats(list)
+	data  := make([]*Export_Host_Stats, len(hosts))
+
+	for i, entry := range hosts {
+		data[i] = &Export_Host_Stats{
+			Host:    entry.host,
+			Seconds: entry.seconds,
+			Frames:  entry.frames,
+		}
+	}
+
+	return data
+}
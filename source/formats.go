@@ -0,0 +1,227 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "fmt"
+import "strings"
+import "path/filepath"
+
+// an image type is one of Blender's image_settings
+// file formats, the colour depths and codecs it will
+// accept, and what we fall back to when the user
+// only gives us the format
+type Image_Type struct {
+	Format        string
+	Extensions    []string
+	Depths        []string
+	Default_Depth string
+	Codecs        []string
+	Default_Codec string
+	codec_setting string
+}
+
+var image_types = []*Image_Type{
+	{Format: "BMP",      Extensions: []string{".bmp"}},
+	{Format: "IRIS",     Extensions: []string{".rgb", ".iris"}},
+	{Format: "JPEG",     Extensions: []string{".jpg", ".jpeg"}},
+	{Format: "TARGA",    Extensions: []string{".tga"}},
+	{Format: "HDR",      Extensions: []string{".hdr"}},
+	{Format: "WEBP",     Extensions: []string{".webp"}},
+	{Format: "CINEON",   Extensions: []string{".cin", ".cineon"}},
+
+	// not a real extension, but it's
+	// the only way to ask for it
+	{Format: "TARGA_RAW", Extensions: []string{".rawtga"}},
+
+	{
+		Format:        "PNG",
+		Extensions:    []string{".png"},
+		Depths:        []string{"8", "16"},
+		Default_Depth: "8",
+	},
+	{
+		Format:        "DPX",
+		Extensions:    []string{".dpx"},
+		Depths:        []string{"8", "10", "12", "16"},
+		Default_Depth: "10",
+	},
+	{
+		Format:        "JPEG2000",
+		Extensions:    []string{".jp2", ".j2c"},
+		Depths:        []string{"8", "12", "16"},
+		Default_Depth: "8",
+		Codecs:        []string{"JP2", "J2K"},
+		Default_Codec: "JP2",
+		codec_setting: "jpeg2k_codec",
+	},
+	{
+		Format:        "TIFF",
+		Extensions:    []string{".tif", ".tiff"},
+		Depths:        []string{"8", "16"},
+		Default_Depth: "16",
+		Codecs:        []string{"NONE", "DEFLATE", "LZW", "PACKBITS"},
+		Default_Codec: "DEFLATE",
+		codec_setting: "tiff_codec",
+	},
+	{
+		Format:        "OPEN_EXR",
+		Extensions:    []string{".exr"},
+		Depths:        []string{"16", "32"},
+		Default_Depth: "16",
+		Codecs:        []string{"NONE", "PXR24", "ZIP", "PIZ", "RLE", "ZIPS", "B44", "B44A", "DWAA", "DWAB"},
+		Default_Codec: "ZIP",
+		codec_setting: "exr_codec",
+	},
+	{
+		// the same file extension as OPEN_EXR,
+		// so it's only reachable by name
+		Format:        "OPEN_EXR_MULTILAYER",
+		Extensions:    []string{".multilayer"},
+		Depths:        []string{"16", "32"},
+		Default_Depth: "16",
+		Codecs:        []string{"NONE", "PXR24", "ZIP", "PIZ", "RLE", "ZIPS", "B44", "B44A", "DWAA", "DWAB"},
+		Default_Codec: "ZIP",
+		codec_setting: "exr_codec",
+	},
+}
+
+// accepts Blender's own name for the format or
+// any of its file extensions, with or without
+// the leading dot
+func get_image_type(name string) (*Image_Type, bool) {
+	name = strings.ToLower(name)
+
+	if !strings.HasPrefix(name, ".") {
+		for _, t := range image_types {
+			if strings.ToLower(t.Format) == name {
+				return t, true
+			}
+		}
+		name = "." + name
+	}
+
+	for _, t := range image_types {
+		for _, ext := range t.Extensions {
+			if ext == name {
+				return t, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// parses format specs in the form
+//   format[:depth[:codec]]
+// such as "exr:32:dwaa", "png:16" or "jpeg"
+func parse_format(spec string) (*Image_Type, string, string, bool) {
+	part := strings.Split(spec, ":")
+
+	if len(part) > 3 {
+		eprintf(apply_color("Format $1%q$0 should be format[:depth[:codec]]\n"), spec)
+		return nil, "", "", false
+	}
+
+	image_type, ok := get_image_type(part[0])
+	if !ok {
+		eprintf(apply_color("Format $1%q$0 is not a known image format\n"), part[0])
+		return nil, "", "", false
+	}
+
+	depth := image_type.Default_Depth
+	codec := image_type.Default_Codec
+
+	if len(part) > 1 && part[1] != "" {
+		depth = part[1]
+		if !contains(image_type.Depths, depth) {
+			eprintf(apply_color("%s does not support a colour depth of $1%s$0\n"), image_type.Format, depth)
+			return nil, "", "", false
+		}
+	}
+
+	if len(part) > 2 && part[2] != "" {
+		codec = strings.ToUpper(part[2])
+		if !contains(image_type.Codecs, codec) {
+			eprintf(apply_color("%s does not support the $1%s$0 codec\n"), image_type.Format, codec)
+			return nil, "", "", false
+		}
+	}
+
+	return image_type, depth, codec, true
+}
+
+// resolves the order's output format from --format,
+// or failing that, from the extension of the output
+// path.  no match on the extension leaves the format
+// to the file
+func resolve_format(order *Order, spec, output_path string) bool {
+	if spec == "" {
+		image_type, ok := get_image_type(filepath.Ext(output_path))
+		if !ok {
+			return true
+		}
+		spec = image_type.Format
+	}
+
+	image_type, depth, codec, ok := parse_format(spec)
+	if !ok {
+		return false
+	}
+
+	order.File_Format = image_type.Format
+	order.Color_Depth = depth
+	order.Codec       = codec
+
+	return true
+}
+
+func format_image_type(order *Order) string {
+	buffer := strings.Builder{}
+	buffer.WriteString(order.File_Format)
+
+	if order.Color_Depth != "" {
+		buffer.WriteString(", ")
+		buffer.WriteString(order.Color_Depth)
+		buffer.WriteString("-bit")
+	}
+
+	if order.Codec != "" {
+		buffer.WriteString(", ")
+		buffer.WriteString(order.Codec)
+	}
+
+	return buffer.String()
+}
+
+func inject_format(buffer *strings.Builder, order *Order) {
+	if order.File_Format == "" {
+		return
+	}
+
+	buffer.WriteString(fmt.Sprintf("bpy.context.scene.render.image_settings.file_format = %q\n", order.File_Format))
+
+	if order.Color_Depth != "" {
+		buffer.WriteString(fmt.Sprintf("bpy.context.scene.render.image_settings.color_depth = %q\n", order.Color_Depth))
+	}
+
+	image_type, ok := get_image_type(order.File_Format)
+	if ok && order.Codec != "" && image_type.codec_setting != "" {
+		buffer.WriteString(fmt.Sprintf("bpy.context.scene.render.image_settings.%s = %q\n", image_type.codec_setting, order.Codec))
+	}
+}
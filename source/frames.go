@@ -0,0 +1,365 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "fmt"
+import "sort"
+import "strings"
+
+type Marker struct {
+	Name  string
+	Frame int
+}
+
+// applies a --frame spec to the order.  a single range
+// stays a plain start, end and step, like the ones read
+// from the file, while anything discontiguous is stored
+// as an explicit frame list in Frames
+func apply_frames(order *Order, spec string, scene *Scene_Info) bool {
+	items := strings.Split(spec, ",")
+
+	if len(items) == 1 {
+		start, end, step, ok := parse_frame_range(items[0], scene)
+		if !ok {
+			return false
+		}
+
+		order.Start_Frame = start
+		order.End_Frame   = end
+		order.Frames      = ""
+
+		if step > 0 {
+			order.Frame_Step = step
+		}
+		return true
+	}
+
+	set := make(map[int]bool, 64)
+
+	for _, item := range items {
+		// lone numbers in a list are
+		// frames, not end frames
+		if frame, ok := parse_int(strings.TrimSpace(item)); ok {
+			set[frame] = true
+			continue
+		}
+
+		start, end, step, ok := parse_frame_range(item, scene)
+		if !ok {
+			return false
+		}
+
+		if step == 0 {
+			step = 1
+		}
+
+		for f := start; f <= end; f += int(step) {
+			set[f] = true
+		}
+	}
+
+	list := make([]int, 0, len(set))
+	for f := range set {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+
+	order.Frames      = format_frames(list, "-")
+	order.Start_Frame = list[0]
+	order.End_Frame   = list[len(list) - 1]
+	order.Frame_Step  = 0
+
+	return true
+}
+
+// parses one item of a frame spec:
+//   end
+//   start:end[:step]
+//   start-end
+//   @marker
+// where any of start and end may also be a marker
+// and frames below zero are written as usual, so
+// "-24-10" is the range from -24 to 10
+func parse_frame_range(item string, scene *Scene_Info) (int, int, uint, bool) {
+	item = strings.TrimSpace(item)
+
+	if strings.HasPrefix(item, "@") && !strings.Contains(item, ":") {
+		return marker_range(item[1:], scene)
+	}
+
+	var part []string
+
+	if strings.Contains(item, ":") {
+		part = strings.Split(item, ":")
+	} else if i := range_dash(item); i > 0 {
+		part = []string{item[:i], item[i + 1:]}
+	} else {
+		part = []string{"1", item}
+	}
+
+	if len(part) > 3 {
+		eprintf(apply_color("Frame range $1%q$0 has too many parts\n"), item)
+		return 0, 0, 0, false
+	}
+
+	start, ok := parse_frame(part[0], scene)
+	if !ok {
+		return 0, 0, 0, false
+	}
+
+	end, ok := parse_frame(part[1], scene)
+	if !ok {
+		return 0, 0, 0, false
+	}
+
+	if end < start {
+		eprintf(apply_color("Frame range $1%q$0 ends before it starts\n"), item)
+		return 0, 0, 0, false
+	}
+
+	step := uint(0)
+	if len(part) == 3 {
+		step, ok = parse_uint(part[2])
+		if !ok || step == 0 {
+			eprintf(apply_color("Frame step $1%q$0 is not valid\n"), part[2])
+			return 0, 0, 0, false
+		}
+	}
+
+	return start, end, step, true
+}
+
+// the dash separating a range is the first one
+// that follows a digit, rather than a minus sign
+func range_dash(item string) int {
+	for i := 1; i < len(item); i++ {
+		if item[i] == '-' && item[i - 1] >= '0' && item[i - 1] <= '9' {
+			return i
+		}
+	}
+	return -1
+}
+
+func parse_frame(str string, scene *Scene_Info) (int, bool) {
+	if strings.HasPrefix(str, "@") {
+		marker, ok := scene.find_marker(str[1:])
+		if !ok {
+			return 0, false
+		}
+		return marker.Frame, true
+	}
+
+	frame, ok := parse_int(str)
+	if !ok {
+		eprintf(apply_color("Frame $1%q$0 is not a number or a marker\n"), str)
+		return 0, false
+	}
+	return frame, true
+}
+
+// a lone marker covers everything up to the next
+// marker, or the end of the scene if it's the last
+func marker_range(name string, scene *Scene_Info) (int, int, uint, bool) {
+	marker, ok := scene.find_marker(name)
+	if !ok {
+		return 0, 0, 0, false
+	}
+
+	end := scene.End_Frame
+	for _, other := range scene.Markers {
+		if other.Frame > marker.Frame && other.Frame - 1 < end {
+			end = other.Frame - 1
+		}
+	}
+
+	if end < marker.Frame {
+		end = marker.Frame
+	}
+
+	return marker.Frame, end, 0, true
+}
+
+func (scene *Scene_Info) find_marker(name string) (*Marker, bool) {
+	for i := range scene.Markers {
+		if scene.Markers[i].Name == name {
+			return &scene.Markers[i], true
+		}
+	}
+
+	names := make([]string, 0, len(scene.Markers))
+	for _, marker := range scene.Markers {
+		names = append(names, marker.Name)
+	}
+
+	eprintf(apply_color("Marker $1%q$0 not found in scene %q, which has: %s\n"), name, scene.Name, strings.Join(names, ", "))
+	return nil, false
+}
+
+// compresses a sorted frame list back into a spec,
+// joining runs with sep: "-" for our own manifests
+// and ".." for Blender's -f argument
+func format_frames(list []int, sep string) string {
+	buffer := strings.Builder{}
+
+	for i := 0; i < len(list); i++ {
+		j := i
+		for j + 1 < len(list) && list[j + 1] == list[j] + 1 {
+			j++
+		}
+
+		if buffer.Len() > 0 {
+			buffer.WriteByte(',')
+		}
+
+		if j > i {
+			buffer.WriteString(fmt.Sprintf("%d%s%d", list[i], sep, list[j]))
+		} else {
+			buffer.WriteString(fmt.Sprintf("%d", list[i]))
+		}
+
+		i = j
+	}
+
+	return buffer.String()
+}
+
+// every frame the order renders, in order
+func (order *Order) frame_list() []int {
+	if order.frame_set != nil {
+		return order.frame_set
+	}
+
+	var list []int
+
+	if order.Frames != "" {
+		list = expand_frames(order.Frames)
+	} else {
+		list = make([]int, 0, 64)
+
+		step := order.Frame_Step
+		if step == 0 {
+			step = 1
+		}
+
+		for f := order.Start_Frame; f <= order.End_Frame; f += int(step) {
+			list = append(list, f)
+		}
+	}
+
+	order.frame_set = list
+	return list
+}
+
+// the frames being rendered: just the redo's
+// frames if there is one, or else all of them
+func (order *Order) render_list() []int {
+	if order.Redo_Frames == "" {
+		return order.frame_list()
+	}
+
+	if order.redo_set == nil {
+		order.redo_set = expand_frames(order.Redo_Frames)
+	}
+	return order.redo_set
+}
+
+// whether Blender has to be given the frames one by
+// one, rather than the scene's range and step
+func (order *Order) is_frame_list() bool {
+	return order.Frames != "" || order.Redo_Frames != ""
+}
+
+func (order *Order) clear_redo() {
+	order.Redo_Frames = ""
+	order.redo_set    = nil
+}
+
+// the reverse of format_frames
+func expand_frames(spec string) []int {
+	list := make([]int, 0, 64)
+
+	for _, item := range strings.Split(spec, ",") {
+		start, _ := parse_int(item)
+		end := start
+
+		if i := range_dash(item); i > 0 {
+			start, _ = parse_int(item[:i])
+			end,   _ = parse_int(item[i + 1:])
+		}
+
+		for f := start; f <= end; f++ {
+			list = append(list, f)
+		}
+	}
+
+	return list
+}
+
+// the frames being rendered that fall between start and end
+func (order *Order) frames_between(start, end int) []int {
+	list := order.render_list()
+
+	i := sort.Search(len(list), func(i int) bool { return list[i] >= start })
+	j := sort.Search(len(list), func(i int) bool { return list[i] > end })
+
+	if i >= j {
+		return nil
+	}
+	return list[i:j]
+}
+
+// how far through the order a frame is, as a
+// percentage of the frames it actually renders
+func (order *Order) frame_percentage(frame int) uint {
+	list := order.render_list()
+	if len(list) < 2 {
+		return 100
+	}
+
+	i := sort.Search(len(list), func(i int) bool { return list[i] >= frame })
+	return uint(float64(i) / float64(len(list) - 1) * 100)
+}
+
+// Blender's -f reads a leading minus as counting back
+// from the end of the scene, so frames below zero are
+// given relative to the start frame with a plus instead
+func blender_frames(list []int, start int) string {
+	if len(list) == 0 || list[0] >= 0 {
+		return format_frames(list, "..")
+	}
+
+	buffer := strings.Builder{}
+
+	for _, item := range strings.Split(format_frames(list, ".."), ",") {
+		if buffer.Len() > 0 {
+			buffer.WriteByte(',')
+		}
+
+		for i, part := range strings.SplitN(item, "..", 2) {
+			if i > 0 {
+				buffer.WriteString("..")
+			}
+
+			frame, _ := parse_int(part)
+			buffer.WriteString(fmt.Sprintf("+%d", frame - start))
+		}
+	}
+
+	return buffer.String()
+}
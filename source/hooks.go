@@ -0,0 +1,119 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "os"
+import "fmt"
+import "os/exec"
+import "path/filepath"
+
+const (
+	HOOK_QUEUE_START   = "queue_start"
+	HOOK_BEFORE_ORDER  = "before_order"
+	HOOK_ORDER_SUCCESS = "order_success"
+	HOOK_ORDER_FAILURE = "order_failure"
+	HOOK_QUEUE_DRAIN   = "queue_drain"
+)
+
+// each hook is a single shell command, run from the
+// project directory with the details of the order
+// (if any) passed in through the environment
+type Hook_Config struct {
+	Queue_Start   string `toml:"queue_start"`
+	Before_Order  string `toml:"before_order"`
+	Order_Success string `toml:"order_success"`
+	Order_Failure string `toml:"order_failure"`
+	Queue_Drain   string `toml:"queue_drain"`
+}
+
+func (hooks *Hook_Config) command(event string) string {
+	switch event {
+	case HOOK_QUEUE_START:
+		return hooks.Queue_Start
+	case HOOK_BEFORE_ORDER:
+		return hooks.Before_Order
+	case HOOK_ORDER_SUCCESS:
+		return hooks.Order_Success
+	case HOOK_ORDER_FAILURE:
+		return hooks.Order_Failure
+	case HOOK_QUEUE_DRAIN:
+		return hooks.Queue_Drain
+	}
+	return ""
+}
+
+// a failing hook is reported but never stops the
+// queue, because a broken chat script shouldn't be
+// able to hold up an overnight render
+func run_hook(config *Config, event string, order *Order, extra ...string) {
+	command := config.Hooks.command(event)
+	if command == "" {
+		return
+	}
+
+	env := append(os.Environ(),
+		"SOUS_HOOK=" + event,
+		"SOUS_PROJECT=" + config.project_dir,
+		"SOUS_HOST=" + config.own_hostname,
+	)
+
+	if order != nil {
+		env = append(env, order_environment(config, order)...)
+	}
+
+	env = append(env, extra...)
+
+	the_command := exec.Command(HOOK_SHELL, HOOK_SHELL_FLAG, command)
+	the_command.Dir    = config.project_dir
+	the_command.Env    = env
+	the_command.Stdout = os.Stdout
+	the_command.Stderr = os.Stderr
+
+	if err := the_command.Run(); err != nil {
+		eprintf(apply_color("$1%s$0 hook failed: %s\n"), event, err.Error())
+	}
+}
+
+func order_environment(config *Config, order *Order) []string {
+	frames := order.Frames
+	if frames == "" {
+		frames = fmt.Sprintf("%d-%d", order.Start_Frame, order.End_Frame)
+	}
+
+	error_frame := ""
+	if order.Error_Frame != nil {
+		error_frame = fmt.Sprint(*order.Error_Frame)
+	}
+
+	return []string{
+		"SOUS_ORDER="         + order.Name,
+		"SOUS_STATUS="        + order.Status.String(),
+		"SOUS_SOURCE="        + filepath.Join(config.project_dir, filepath.FromSlash(order.Source_Path)),
+		"SOUS_OUTPUT="        + filepath.Join(config.project_dir, filepath.FromSlash(order.Output_Path)),
+		"SOUS_SCENE="         + order.Scene,
+		"SOUS_FRAMES="        + frames,
+		"SOUS_START_FRAME="   + fmt.Sprint(order.Start_Frame),
+		"SOUS_END_FRAME="     + fmt.Sprint(order.End_Frame),
+		"SOUS_FRAME_STEP="    + fmt.Sprint(order.Frame_Step),
+		"SOUS_ERROR="         + order.Error_Kind.Name(),
+		"SOUS_ERROR_MESSAGE=" + order.Error_Message,
+		"SOUS_ERROR_FRAME="   + error_frame,
+		"SOUS_EXIT_CODE="     + fmt.Sprint(order.Exit_Code),
+	}
+}
@@ -0,0 +1,413 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "os"
+import "fmt"
+import "time"
+import "bytes"
+import "strings"
+import "sync"
+import "github.com/BurntSushi/toml"
+
+const DEFAULT_LOCK_TIMEOUT = 10 // minutes
+const HEARTBEAT_INTERVAL   = 30 * time.Second
+
+type Lock struct {
+	Host      string    `toml:"host"`
+	PID       int       `toml:"pid"`
+	Started   time.Time `toml:"started"`
+	Heartbeat time.Time `toml:"heartbeat"`
+
+	// what the machine is doing right now, for status:
+	// frames done and to do in the current run of Blender
+	Run_Started time.Time `toml:"run_started"`
+	Frame       int       `toml:"frame"`
+	Done        uint      `toml:"done"`
+	Total       uint      `toml:"total"`
+
+	mutex sync.Mutex
+	lost  bool // another machine took it over
+}
+
+func new_lock(config *Config) *Lock {
+	now := time.Now()
+
+	return &Lock{
+		Host:      config.own_hostname,
+		PID:       os.Getpid(),
+		Started:   now,
+		Heartbeat: now,
+	}
+}
+
+func lock_timeout(config *Config) time.Duration {
+	if config.Lock_Timeout == 0 {
+		return DEFAULT_LOCK_TIMEOUT * time.Minute
+	}
+	return time.Duration(config.Lock_Timeout) * time.Minute
+}
+
+// a lock whose heartbeat hasn't been refreshed in
+// a while most likely belongs to a machine that
+// crashed or lost power mid-render
+func (lock *Lock) is_stale(config *Config) bool {
+	return time.Since(lock.Heartbeat) > lock_timeout(config)
+}
+
+// the process that created a lock can always reclaim it,
+// as can anyone once its heartbeat has gone stale.  on the
+// same machine, a lock whose process has exited is as good
+// as stale, so a crash doesn't mean waiting out the timeout
+func can_claim(config *Config, lock *Lock) bool {
+	if lock == nil || lock.is_stale(config) {
+		return true
+	}
+
+	if lock.Host != config.own_hostname {
+		return false
+	}
+
+	return lock.PID == os.Getpid() || !process_exists(lock.PID)
+}
+
+// whether any machine is still working on the order,
+// either as a whole or on one of its chunks
+func order_in_use(config *Config, order *Order) bool {
+	if order.lock != nil && !order.lock.is_stale(config) {
+		return true
+	}
+
+	if order.Chunk_Size > 0 {
+		for _, chunk := range load_chunks(config.project_dir, order) {
+			if chunk.lock != nil && !chunk.lock.is_stale(config) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (lock *Lock) same_owner(other *Lock) bool {
+	return lock.Host == other.Host && lock.PID == other.PID
+}
+
+func read_lock(path string) (*Lock, bool) {
+	blob, ok := load_file(path)
+	if !ok {
+		return nil, false
+	}
+
+	lock := new(Lock)
+
+	// older versions of Sous Chef only wrote the hostname
+	// into the lock.  those, and locks caught half-written,
+	// count as fresh from when the file was last touched
+	if _, err := toml.Decode(blob, lock); err != nil || lock.Host == "" {
+		lock = &Lock{
+			Host: strings.TrimSpace(blob),
+		}
+
+		if info, err := os.Stat(path); err == nil {
+			lock.Heartbeat = info.ModTime()
+		}
+	}
+
+	return lock, true
+}
+
+func write_lock(path string, lock *Lock) bool {
+	buffer := bytes.Buffer{}
+	buffer.Grow(128)
+
+	if err := toml.NewEncoder(&buffer).Encode(lock); err != nil {
+		eprintln("Failed to encode lock file")
+		return false
+	}
+
+	return write_file(path, buffer.String())
+}
+
+// creates the lock file exclusively, which is the only
+// thing that network shares reliably refuse to a second
+// machine.  reading back what we wrote is no good, as
+// each client may only be reading its own cache
+func create_lock(path string, lock *Lock) bool {
+	buffer := bytes.Buffer{}
+	buffer.Grow(128)
+
+	if err := toml.NewEncoder(&buffer).Encode(lock); err != nil {
+		eprintln("Failed to encode lock file")
+		return false
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE | os.O_EXCL | os.O_WRONLY, 0644)
+	if err != nil {
+		return false
+	}
+
+	_, err = file.Write(buffer.Bytes())
+
+	if close_err := file.Close(); err == nil {
+		err = close_err
+	}
+
+	if err != nil {
+		os.Remove(path)
+		return false
+	}
+
+	return true
+}
+
+// a lock that can be claimed is removed and created
+// afresh, so if two machines replace the same stale
+// lock at once, only one of them gets to create it.
+// should one remove the other's new lock in between,
+// the first one's heartbeat notices and stands down
+func claim_lock(config *Config, path string) (*Lock, bool) {
+	lock := new_lock(config)
+
+	if create_lock(path, lock) {
+		return lock, true
+	}
+
+	existing, ok := read_lock(path)
+	if !ok || !can_claim(config, existing) {
+		return nil, false
+	}
+
+	os.Remove(path)
+
+	if !create_lock(path, lock) {
+		return nil, false
+	}
+
+	return lock, true
+}
+
+// removes the lock, unless someone else has taken
+// it over, in which case it's theirs to remove
+func release_lock(path string, lock *Lock) {
+	if lock == nil {
+		return
+	}
+
+	if current, ok := read_lock(path); ok && !current.same_owner(lock) {
+		return
+	}
+
+	os.Remove(path)
+}
+
+// rewrites our lock, as long as it's still ours: another
+// machine may have decided it was stale and taken it.
+// once lost, nothing more is written.  expects the
+// lock's mutex to be held
+func (lock *Lock) refresh(path string) bool {
+	if lock.lost {
+		return false
+	}
+
+	current, ok := read_lock(path)
+	if !ok || !current.same_owner(lock) {
+		lock.lost = true
+		return false
+	}
+
+	write_lock(path, lock)
+	return true
+}
+
+func (lock *Lock) is_lost() bool {
+	if lock == nil {
+		return false
+	}
+
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+
+	return lock.lost
+}
+
+// the lock covering either the whole order or
+// just the chunk that's being rendered
+func active_lock(config *Config, order *Order, chunk *Chunk) (*Lock, string) {
+	if chunk != nil {
+		return chunk.lock, chunk_lock_path(config.project_dir, order.Name, chunk.index)
+	}
+	return order.lock, lock_path(config.project_dir, order.Name)
+}
+
+// resets the lock's progress for a new run of Blender
+func (lock *Lock) start_run(path string, total int) bool {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+
+	lock.Run_Started = time.Now()
+	lock.Heartbeat   = lock.Run_Started
+	lock.Frame       = 0
+	lock.Done        = 0
+	lock.Total       = uint(total)
+
+	return lock.refresh(path)
+}
+
+// Blender repeats its "Fra:" lines many times per frame,
+// so the lock is only rewritten when something changes
+func (lock *Lock) set_progress(path string, frame int, saved bool) bool {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+
+	if frame == lock.Frame && !saved {
+		return !lock.lost
+	}
+
+	lock.Frame     = frame
+	lock.Heartbeat = time.Now()
+
+	if saved {
+		lock.Done += 1
+	}
+
+	return lock.refresh(path)
+}
+
+// keeps the lock's heartbeat fresh until the returned
+// function is called to stop it.  if the lock is taken
+// over, the heartbeat stops and on_lost is called
+func start_heartbeat(path string, lock *Lock, on_lost func()) func() {
+	stop := make(chan bool)
+	done := make(chan bool)
+
+	go func() {
+		ticker := time.NewTicker(HEARTBEAT_INTERVAL)
+		defer ticker.Stop()
+		defer close(done)
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				lock.mutex.Lock()
+				lock.Heartbeat = time.Now()
+				ours := lock.refresh(path)
+				lock.mutex.Unlock()
+
+				if !ours {
+					if on_lost != nil {
+						on_lost()
+					}
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+func command_locks(config *Config, args *Arguments) {
+	queue, ok := load_orders(config.project_dir, false)
+	if !ok {
+		return
+	}
+
+	if args.break_lock != "" {
+		for _, order := range queue {
+			if order.Name == args.break_lock {
+				os.Remove(lock_path(config.project_dir, order.Name))
+
+				for _, chunk := range load_chunks(config.project_dir, order) {
+					os.Remove(chunk_lock_path(config.project_dir, order.Name, chunk.index))
+				}
+
+				printf(apply_color("[$1%s$0] locks released\n"), order.Name)
+				return
+			}
+		}
+
+		eprintf(apply_color("Order $1%q$0 does not exist\n"), args.break_lock)
+		return
+	}
+
+	if args.output != OUTPUT_TEXT {
+		data := &Export_Locks{
+			Schema_Version: SCHEMA_VERSION,
+			Locks:          make([]*Export_Lock, 0, 8),
+		}
+
+		for _, order := range queue {
+			data.Locks = append(data.Locks, export_locks(config, order)...)
+		}
+
+		write_data(args.output, data)
+		return
+	}
+
+	count := 0
+
+	print_lock := func(name string, lock *Lock) {
+		if count == 0 {
+			printf("%-14s %-20s %-8s %-10s %s\n", "Order", "Host", "PID", "Age", "Heartbeat")
+		}
+		count++
+
+		age := "?"
+		if !lock.Started.IsZero() {
+			age = format_duration(time.Since(lock.Started))
+		}
+
+		heartbeat := "never"
+		if !lock.Heartbeat.IsZero() {
+			heartbeat = format_duration(time.Since(lock.Heartbeat)) + " ago"
+		}
+
+		if lock.is_stale(config) {
+			printf(apply_color("%-14s %-20s %-8d %-10s %s $1stale$0\n"), name, lock.Host, lock.PID, age, heartbeat)
+		} else {
+			printf("%-14s %-20s %-8d %-10s %s\n", name, lock.Host, lock.PID, age, heartbeat)
+		}
+	}
+
+	for _, order := range queue {
+		if order.lock != nil {
+			print_lock(order.Name, order.lock)
+		}
+
+		if order.Chunk_Size == 0 {
+			continue
+		}
+
+		for _, chunk := range load_chunks(config.project_dir, order) {
+			if chunk.lock != nil {
+				print_lock(fmt.Sprintf("%s/%d", order.Name, chunk.index + 1), chunk.lock)
+			}
+		}
+	}
+
+	if count == 0 {
+		printf("No locks held\n")
+	}
+}
@@ -0,0 +1,191 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "os"
+import "io"
+import "fmt"
+import "sort"
+import "sync"
+import "time"
+import "path/filepath"
+
+const DEFAULT_LOG_LIMIT = 10
+
+// every run of Blender gets its own log file in the
+// order's log directory, holding both stdout and
+// stderr, so failures can be looked at afterwards
+// from any machine that can see the queue
+type Run_Log struct {
+	file  *os.File
+	mutex sync.Mutex
+}
+
+func create_log(config *Config, order *Order, chunk *Chunk) (*Run_Log, bool) {
+	dir := log_dir(config.project_dir, order.Name)
+
+	if !make_directory(dir) {
+		return nil, false
+	}
+
+	// timestamps go first so that the names sort
+	// in chronological order, and have to be fine
+	// enough that a quick retry gets its own file
+	name := time.Now().Format("2006-01-02_150405.000") + "_" + config.own_hostname
+	if chunk != nil {
+		name += fmt.Sprintf("_chunk%d", chunk.index + 1)
+	}
+
+	// a previous run's log is never overwritten,
+	// even if the name somehow turns up twice
+	path := filepath.Join(dir, name + ".log")
+
+	file, err := os.OpenFile(path, os.O_CREATE | os.O_EXCL | os.O_WRONLY, 0644)
+	for n := 2; os.IsExist(err); n++ {
+		path = filepath.Join(dir, fmt.Sprintf("%s_%d.log", name, n))
+		file, err = os.OpenFile(path, os.O_CREATE | os.O_EXCL | os.O_WRONLY, 0644)
+	}
+
+	if err != nil {
+		eprintf("Failed to create log file for %q\n", order.Name)
+		return nil, false
+	}
+
+	prune_logs(config.project_dir, order.Name, log_limit(config))
+
+	return &Run_Log{file: file}, true
+}
+
+// safe to call on a nil log, so a failure to create
+// one never stops the render from going ahead
+func (log *Run_Log) write(line string) {
+	if log == nil {
+		return
+	}
+
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	log.file.WriteString(line)
+	log.file.WriteString("\n")
+}
+
+func (log *Run_Log) close() {
+	if log == nil {
+		return
+	}
+	log.file.Close()
+}
+
+func log_limit(config *Config) int {
+	if config.Log_Limit == 0 {
+		return DEFAULT_LOG_LIMIT
+	}
+	return int(config.Log_Limit)
+}
+
+// returns the log files for an order, newest first
+func list_logs(project_dir, name string) []string {
+	dir := log_dir(project_dir, name)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	list := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".log" {
+			continue
+		}
+		list = append(list, filepath.Join(dir, entry.Name()))
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(list)))
+
+	return list
+}
+
+func prune_logs(project_dir, name string, limit int) {
+	list := list_logs(project_dir, name)
+
+	if len(list) <= limit {
+		return
+	}
+
+	for _, path := range list[limit:] {
+		os.Remove(path)
+	}
+}
+
+func command_log(config *Config, args *Arguments) {
+	if args.source_path == "" {
+		eprintln("No order name was provided!")
+		return
+	}
+
+	if !file_exists(manifest_path(config.project_dir, args.source_path)) {
+		eprintf(apply_color("Order $1%q$0 does not exist\n"), args.source_path)
+		return
+	}
+
+	list := list_logs(config.project_dir, args.source_path)
+
+	if len(list) == 0 {
+		printf(apply_color("[$1%s$0] has no logs yet\n"), args.source_path)
+		return
+	}
+
+	run := args.log_run
+	if run == 0 {
+		run = 1
+	}
+
+	if int(run) > len(list) {
+		eprintf(apply_color("[$1%s$0] only has %d logged runs\n"), args.source_path, len(list))
+		return
+	}
+
+	file, err := os.Open(list[run - 1])
+	if err != nil {
+		eprintf("Failed to open log %q\n", list[run - 1])
+		return
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(os.Stdout, file); err != nil {
+		return
+	}
+
+	if !args.follow_log {
+		return
+	}
+
+	// the file may be written by another machine on
+	// a shared volume, so we can't rely on any kind
+	// of filesystem notification and just poll it
+	for {
+		time.Sleep(500 * time.Millisecond)
+
+		if _, err := io.Copy(os.Stdout, file); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,579 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "os"
+import "fmt"
+import "net"
+import "time"
+import "bytes"
+import "errors"
+import "strings"
+import "os/exec"
+import "net/http"
+import "net/smtp"
+import "crypto/tls"
+import "text/template"
+import "encoding/json"
+
+const (
+	NOTIFY_ORDER_COMPLETE = "order_complete"
+	NOTIFY_ORDER_FAILED   = "order_failed"
+	NOTIFY_QUEUE_FINISHED = "queue_finished"
+	NOTIFY_STALE_LOCK     = "stale_lock"
+	NOTIFY_ENCODE_FAILED  = "encode_failed"
+)
+
+const (
+	NOTIFY_WEBHOOK = "webhook"
+	NOTIFY_SMTP    = "smtp"
+	NOTIFY_COMMAND = "command"
+)
+
+const NOTIFY_TIMEOUT = 10 * time.Second
+
+var notify_events = []string{
+	NOTIFY_ORDER_COMPLETE,
+	NOTIFY_ORDER_FAILED,
+	NOTIFY_QUEUE_FINISHED,
+	NOTIFY_STALE_LOCK,
+	NOTIFY_ENCODE_FAILED,
+}
+
+// each [[notify]] section is one place to send messages,
+// using whichever of the fields its kind needs
+type Notifier struct {
+	Name    string   `toml:"name"`
+	Kind    string   `toml:"kind"`
+	Events  []string `toml:"events"`
+	Subject string   `toml:"subject"`
+	Message string   `toml:"message"`
+
+	URL     string            `toml:"url"`
+	Headers map[string]string `toml:"headers"`
+
+	Server       string   `toml:"server"`
+	Username     string   `toml:"username"`
+	Password     string   `toml:"password"`
+	Password_Env string   `toml:"password_env"`
+	From         string   `toml:"from"`
+	To           []string `toml:"to"`
+
+	Command string `toml:"command"`
+}
+
+// everything a message template can refer to, which is
+// also the body of a webhook; the fields that don't apply
+// to an event are left empty
+type Notice struct {
+	Schema_Version int       `json:"schema_version"`
+	Event          string    `json:"event"`
+	Project        string    `json:"project"`
+	Host           string    `json:"host"`
+	Time           time.Time `json:"time"`
+	Subject        string    `json:"subject"`
+	Message        string    `json:"message"`
+
+	Order         string `json:"order,omitempty"`
+	Source        string `json:"source,omitempty"`
+	Output        string `json:"output,omitempty"`
+	Chunk         *int   `json:"chunk,omitempty"`
+	Status        string `json:"status,omitempty"`
+	Error_Kind    string `json:"error_kind,omitempty"`
+	Error_Message string `json:"error_message,omitempty"`
+	Error_Frame   *int   `json:"error_frame,omitempty"`
+
+	Done       int `json:"done"`
+	Total      int `json:"total"`
+	Percentage int `json:"percentage"`
+
+	Elapsed         string  `json:"elapsed"`
+	Elapsed_Seconds float64 `json:"elapsed_seconds"`
+
+	Rendered int `json:"rendered"`
+	Failed   int `json:"failed"`
+
+	Lock_Host string `json:"lock_host,omitempty"`
+	Lock_Age  string `json:"lock_age,omitempty"`
+
+	Encode_Path  string `json:"encode_path,omitempty"`
+	Encode_Error string `json:"encode_error,omitempty"`
+
+	order *Order
+}
+
+var default_templates = map[string][2]string{
+	NOTIFY_ORDER_COMPLETE: {
+		"[{{.Order}}] complete",
+		"[{{.Order}}] {{.Source}} finished on {{.Host}}: {{.Done}}/{{.Total}} frames in {{.Elapsed}}.",
+	},
+	NOTIFY_ORDER_FAILED: {
+		"[{{.Order}}] failed: {{.Error_Kind}}",
+		"[{{.Order}}] {{.Source}} failed on {{.Host}} with {{.Error_Kind}}{{with .Error_Frame}} at frame {{.}}{{end}}.\n{{with .Error_Message}}{{.}}\n{{end}}{{.Done}}/{{.Total}} frames ({{.Percentage}}%) were done after {{.Elapsed}}.",
+	},
+	NOTIFY_QUEUE_FINISHED: {
+		"Queue finished on {{.Host}}",
+		"{{.Host}} has run out of orders after {{.Elapsed}}: {{.Rendered}} rendered, {{.Failed}} failed.",
+	},
+	NOTIFY_STALE_LOCK: {
+		"[{{.Order}}] stale lock",
+		"{{.Host}} took over [{{.Order}}]{{with .Chunk}} chunk {{.}}{{end}} from {{.Lock_Host}}{{with .Lock_Age}}, last heard from {{.}} ago{{end}}.",
+	},
+	NOTIFY_ENCODE_FAILED: {
+		"[{{.Order}}] encode failed",
+		"[{{.Order}}] {{.Source}} rendered, but {{.Host}} couldn't encode {{.Encode_Path}}: {{.Encode_Error}}",
+	},
+}
+
+func (notifier *Notifier) label(index int) string {
+	if notifier.Name != "" {
+		return notifier.Name
+	}
+	return fmt.Sprintf("%s %d", notifier.Kind, index + 1)
+}
+
+// no events means every event
+func (notifier *Notifier) wants(event string) bool {
+	return len(notifier.Events) == 0 || contains(notifier.Events, event)
+}
+
+func (notifier *Notifier) check() error {
+	for _, event := range notifier.Events {
+		if !contains(notify_events, event) {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+
+	switch notifier.Kind {
+	case NOTIFY_WEBHOOK:
+		if notifier.URL == "" {
+			return errors.New("webhook has no url")
+		}
+	case NOTIFY_SMTP:
+		if notifier.Server == "" || notifier.From == "" || len(notifier.To) == 0 {
+			return errors.New("smtp needs a server, from and to")
+		}
+	case NOTIFY_COMMAND:
+		if notifier.Command == "" {
+			return errors.New("command has no command")
+		}
+	default:
+		return fmt.Errorf("unknown kind %q", notifier.Kind)
+	}
+
+	return nil
+}
+
+// notifiers are checked once, when the config is
+// loaded, so a mistake shows up straight away rather
+// than the first time something goes wrong
+func check_notifiers(config *Config) bool {
+	ok := true
+
+	for i, notifier := range config.Notify {
+		if err := notifier.check(); err != nil {
+			eprintf(apply_color("$1%s$0 notifier in config.toml: %s\n"), notifier.label(i), err.Error())
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// like hooks, a notification that can't be sent is
+// reported and then forgotten about: it should never
+// be the reason the queue stops
+func notify(config *Config, notice *Notice) {
+	for i, notifier := range config.Notify {
+		if !notifier.wants(notice.Event) {
+			continue
+		}
+
+		if err := notifier.send(config, notice); err != nil {
+			eprintf(apply_color("$1%s$0 notification failed: %s\n"), notifier.label(i), err.Error())
+		}
+	}
+}
+
+func (notifier *Notifier) send(config *Config, notice *Notice) error {
+	// every notifier gets its own copy, with
+	// its own templates filled in
+	message := *notice
+
+	defaults := default_templates[notice.Event]
+
+	subject, err := fill_template(notifier.Subject, defaults[0], &message)
+	if err != nil {
+		return err
+	}
+
+	body, err := fill_template(notifier.Message, defaults[1], &message)
+	if err != nil {
+		return err
+	}
+
+	message.Subject = subject
+	message.Message = body
+
+	switch notifier.Kind {
+	case NOTIFY_WEBHOOK:
+		return notifier.send_webhook(&message)
+	case NOTIFY_SMTP:
+		return notifier.send_smtp(&message)
+	case NOTIFY_COMMAND:
+		return notifier.run_command(config, &message)
+	}
+
+	return nil
+}
+
+func fill_template(text, fallback string, notice *Notice) (string, error) {
+	if text == "" {
+		text = fallback
+	}
+
+	tmpl, err := template.New("notify").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	buffer := strings.Builder{}
+	if err := tmpl.Execute(&buffer, notice); err != nil {
+		return "", err
+	}
+
+	return buffer.String(), nil
+}
+
+func (notifier *Notifier) send_webhook(notice *Notice) error {
+	blob, err := json.Marshal(notice)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, notifier.URL, bytes.NewReader(blob))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range notifier.Headers {
+		request.Header.Set(key, value)
+	}
+
+	client := http.Client{Timeout: NOTIFY_TIMEOUT}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", response.Status)
+	}
+
+	return nil
+}
+
+// net/smtp's SendMail can wait forever on a server that
+// stops talking, so this is the same thing with a deadline
+func (notifier *Notifier) send_smtp(notice *Notice) error {
+	host, _, err := net.SplitHostPort(notifier.Server)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", notifier.Server, NOTIFY_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(NOTIFY_TIMEOUT))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+
+	if notifier.Username != "" {
+		password := notifier.Password
+		if notifier.Password_Env != "" {
+			password = os.Getenv(notifier.Password_Env)
+		}
+
+		if err := client.Auth(smtp.PlainAuth("", notifier.Username, password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(notifier.From); err != nil {
+		return err
+	}
+
+	for _, to := range notifier.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	header := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n",
+		header_value(notifier.From),
+		header_value(strings.Join(notifier.To, ", ")),
+		header_value(notice.Subject),
+		notice.Time.Format(time.RFC1123Z),
+	)
+
+	body := strings.ReplaceAll(strings.ReplaceAll(notice.Message, "\r\n", "\n"), "\n", "\r\n")
+
+	if _, err := writer.Write([]byte(header + body + "\r\n")); err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// a line break in a header would start a new one,
+// so templates can't be used to add headers
+func header_value(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, text)
+}
+
+// the message is passed in on stdin as well as in
+// the environment, for scripts that prefer either
+func (notifier *Notifier) run_command(config *Config, notice *Notice) error {
+	env := append(os.Environ(),
+		"SOUS_EVENT="   + notice.Event,
+		"SOUS_PROJECT=" + config.project_dir,
+		"SOUS_HOST="    + config.own_hostname,
+		"SOUS_SUBJECT=" + notice.Subject,
+		"SOUS_MESSAGE=" + notice.Message,
+	)
+
+	if notice.order != nil {
+		env = append(env, order_environment(config, notice.order)...)
+	}
+
+	the_command := exec.Command(HOOK_SHELL, HOOK_SHELL_FLAG, notifier.Command)
+	the_command.Dir    = config.project_dir
+	the_command.Env    = env
+	the_command.Stdin  = strings.NewReader(notice.Message)
+	the_command.Stdout = os.Stdout
+	the_command.Stderr = os.Stderr
+
+	return the_command.Run()
+}
+
+func new_notice(config *Config, event string) *Notice {
+	return &Notice{
+		Schema_Version: SCHEMA_VERSION,
+		Event:          event,
+		Project:        config.project_dir,
+		Host:           config.own_hostname,
+		Time:           time.Now(),
+	}
+}
+
+func (notice *Notice) set_elapsed(elapsed time.Duration) {
+	notice.Elapsed         = format_duration(elapsed)
+	notice.Elapsed_Seconds = elapsed.Round(time.Second).Seconds()
+}
+
+func order_notice(config *Config, event string, order *Order, elapsed time.Duration) *Notice {
+	notice := new_notice(config, event)
+
+	notice.order         = order
+	notice.Order         = order.Name
+	notice.Source        = order.Source_Path
+	notice.Output        = order.Output_Path
+	notice.Status        = order.Status.String()
+	notice.Error_Kind    = order.Error_Kind.Name()
+	notice.Error_Message = order.Error_Message
+	notice.Error_Frame   = order.Error_Frame
+
+	progress := order_progress(config, order)
+
+	notice.Done       = progress.Done
+	notice.Total      = progress.Total
+	notice.Percentage = progress.percentage()
+
+	notice.set_elapsed(elapsed)
+
+	return notice
+}
+
+func queue_notice(config *Config, elapsed time.Duration, rendered, failed int) *Notice {
+	notice := new_notice(config, NOTIFY_QUEUE_FINISHED)
+
+	notice.Rendered = rendered
+	notice.Failed   = failed
+
+	notice.set_elapsed(elapsed)
+
+	return notice
+}
+
+// sent when this machine takes over an order or chunk
+// whose lock was left behind by a machine that stopped
+// without cleaning up after itself
+func stale_notice(config *Config, order *Order, chunk *Chunk, lock *Lock) *Notice {
+	notice := order_notice(config, NOTIFY_STALE_LOCK, order, 0)
+
+	if chunk != nil {
+		index := chunk.index + 1
+		notice.Chunk = &index
+	}
+
+	notice.Lock_Host = lock.Host
+
+	// older locks only carry the hostname
+	if !lock.Heartbeat.IsZero() {
+		notice.Lock_Age = format_duration(time.Since(lock.Heartbeat))
+	}
+
+	return notice
+}
+
+// the render itself went fine, so only the
+// encode's own outcome is filled in
+func encode_notice(config *Config, order *Order) *Notice {
+	notice := order_notice(config, NOTIFY_ENCODE_FAILED, order, 0)
+
+	if order.Encoded != nil {
+		notice.Encode_Path  = order.Encoded.Path
+		notice.Encode_Error = order.Encoded.Error
+	}
+
+	return notice
+}
+
+// sends an example of an event to every notifier, or just
+// the named one, so the setup can be tried out without
+// waiting for a real render to finish or fall over
+func command_notify(config *Config, args *Arguments) {
+	if len(config.Notify) == 0 {
+		printf("No notifiers are configured!\n")
+		return
+	}
+
+	event := args.notify_event
+	if event == "" {
+		event = NOTIFY_ORDER_COMPLETE
+	}
+
+	if !contains(notify_events, event) {
+		eprintf(apply_color("Unknown event $1%q$0: use one of %s\n"), event, strings.Join(notify_events, ", "))
+		return
+	}
+
+	notice := example_notice(config, event)
+	found  := false
+
+	for i, notifier := range config.Notify {
+		label := notifier.label(i)
+
+		if args.notifier != "" && label != args.notifier {
+			continue
+		}
+
+		found = true
+
+		if err := notifier.send(config, notice); err != nil {
+			eprintf(apply_color("[$1%s$0] failed: %s\n"), label, err.Error())
+			continue
+		}
+
+		if notifier.wants(event) {
+			printf(apply_color("[$1%s$0] sent %s ✓\n"), label, event)
+		} else {
+			printf(apply_color("[$1%s$0] sent %s ✓ (not normally sent for this event)\n"), label, event)
+		}
+	}
+
+	if !found {
+		eprintf(apply_color("Notifier $1%q$0 does not exist\n"), args.notifier)
+	}
+}
+
+func example_notice(config *Config, event string) *Notice {
+	if event == NOTIFY_QUEUE_FINISHED {
+		return queue_notice(config, 150 * time.Minute, 3, 1)
+	}
+
+	error_frame := 42
+
+	order := &Order{
+		Name:        "example",
+		Source_Path: "shots/example.blend",
+		Output_Path: "renders/example",
+		Start_Frame: 1,
+		End_Frame:   100,
+		Frame_Step:  1,
+		Status:      STATUS_COMPLETE,
+	}
+
+	switch event {
+	case NOTIFY_ORDER_FAILED:
+		order.Last_Frame   = error_frame - 1
+		order.Has_Progress = true
+		order.set_failed(NO_MEMORY, "std::bad_alloc", 1, &error_frame)
+
+	case NOTIFY_ENCODE_FAILED:
+		order.Encoded = &Encode_Result{
+			Status: ENCODE_FAILED,
+			Path:   "/renders/example/example.mp4",
+			Error:  "Unknown encoder 'libx264'",
+		}
+		return encode_notice(config, order)
+
+	case NOTIFY_STALE_LOCK:
+		order.Status       = STATUS_PENDING
+		order.Last_Frame   = error_frame - 1
+		order.Has_Progress = true
+
+		lock := &Lock{
+			Host:      "other-machine",
+			Heartbeat: time.Now().Add(-25 * time.Minute),
+		}
+		return stale_notice(config, order, nil, lock)
+	}
+
+	return order_notice(config, event, order, 47 * time.Minute)
+}
@@ -0,0 +1,267 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "io"
+import "os"
+import "net"
+import "bufio"
+import "strings"
+import "testing"
+import "net/http"
+import "encoding/json"
+import "net/http/httptest"
+
+func test_config(t *testing.T) *Config {
+	return &Config{
+		project_dir:  t.TempDir(),
+		own_hostname: "farm01",
+	}
+}
+
+func TestWebhookBody(t *testing.T) {
+	var (
+		body    []byte
+		headers http.Header
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	config := test_config(t)
+
+	notifier := &Notifier{
+		Kind:    NOTIFY_WEBHOOK,
+		URL:     server.URL,
+		Headers: map[string]string{"Authorization": "Bearer secret"},
+		Subject: "{{.Order}} broke on {{.Host}}",
+	}
+
+	if err := notifier.send(config, example_notice(config, NOTIFY_ORDER_FAILED)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := headers.Get("Content-Type"); got != "application/json" {
+		t.Errorf("content type is %q", got)
+	}
+	if got := headers.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("authorization is %q", got)
+	}
+
+	notice := Notice{}
+	if err := json.Unmarshal(body, &notice); err != nil {
+		t.Fatalf("body isn't a notice: %v\n%s", err, body)
+	}
+
+	if notice.Schema_Version != SCHEMA_VERSION {
+		t.Errorf("schema version is %d", notice.Schema_Version)
+	}
+	if notice.Event != NOTIFY_ORDER_FAILED {
+		t.Errorf("event is %q", notice.Event)
+	}
+	if notice.Subject != "example broke on farm01" {
+		t.Errorf("subject is %q", notice.Subject)
+	}
+	if notice.Error_Kind != NO_MEMORY.Name() {
+		t.Errorf("error kind is %q", notice.Error_Kind)
+	}
+	if notice.Error_Frame == nil || *notice.Error_Frame != 42 {
+		t.Errorf("error frame is %v", notice.Error_Frame)
+	}
+	if !strings.Contains(notice.Message, "std::bad_alloc") {
+		t.Errorf("message is missing the error: %q", notice.Message)
+	}
+}
+
+func TestWebhookFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	config := test_config(t)
+
+	notifier := &Notifier{Kind: NOTIFY_WEBHOOK, URL: server.URL}
+
+	if err := notifier.send(config, example_notice(config, NOTIFY_ORDER_COMPLETE)); err == nil {
+		t.Fatal("a 502 should have been reported")
+	}
+}
+
+// just enough of an SMTP server to take one message,
+// handing back the raw DATA section once it's done
+func fake_smtp(t *testing.T) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	data := make(chan string, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		reply  := func(line string) { io.WriteString(conn, line + "\r\n") }
+
+		reply("220 localhost ready")
+
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			command := strings.ToUpper(strings.TrimSpace(line))
+
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+
+			case command == "DATA":
+				reply("354 go ahead")
+
+				buffer := strings.Builder{}
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					buffer.WriteString(line)
+				}
+
+				data <- buffer.String()
+				reply("250 queued")
+
+			case command == "QUIT":
+				reply("221 bye")
+				return
+
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), data
+}
+
+func TestSMTPMessage(t *testing.T) {
+	addr, data := fake_smtp(t)
+
+	config := test_config(t)
+
+	notifier := &Notifier{
+		Kind:    NOTIFY_SMTP,
+		Server:  addr,
+		From:    "farm@example.com",
+		To:      []string{"a@example.com", "b@example.com"},
+		Subject: "{{.Order}}\r\nBcc: someone@example.com",
+		Message: "first line\nsecond line\r\nthird line",
+	}
+
+	if err := notifier.send(config, example_notice(config, NOTIFY_ORDER_COMPLETE)); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := <-data
+
+	// every line has to end in CRLF, with no bare LFs
+	// or CRs left over from the templates
+	for _, line := range strings.SplitAfter(raw, "\n") {
+		if line == "" {
+			continue
+		}
+		if !strings.HasSuffix(line, "\r\n") || strings.Count(line, "\r") != 1 {
+			t.Errorf("line isn't terminated by a lone CRLF: %q", line)
+		}
+	}
+
+	header, body, ok := strings.Cut(raw, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("no blank line between header and body:\n%s", raw)
+	}
+
+	fields := make(map[string]string)
+	for _, line := range strings.Split(header, "\r\n") {
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			t.Errorf("malformed header line %q", line)
+			continue
+		}
+		fields[key] = value
+	}
+
+	if _, ok := fields["Bcc"]; ok {
+		t.Error("the subject template was able to add a header")
+	}
+	if got := fields["Subject"]; got != "example  Bcc: someone@example.com" {
+		t.Errorf("subject is %q", got)
+	}
+	if got := fields["To"]; got != "a@example.com, b@example.com" {
+		t.Errorf("to is %q", got)
+	}
+	if got := fields["From"]; got != "farm@example.com" {
+		t.Errorf("from is %q", got)
+	}
+
+	if body != "first line\r\nsecond line\r\nthird line\r\n" {
+		t.Errorf("body is %q", body)
+	}
+}
+
+func TestCheckNotifiers(t *testing.T) {
+	config := test_config(t)
+
+	config.Notify = []*Notifier{
+		{Kind: NOTIFY_WEBHOOK, URL: "http://localhost"},
+		{Kind: NOTIFY_COMMAND, Command: "true", Events: []string{NOTIFY_ORDER_FAILED}},
+	}
+
+	if !check_notifiers(config) {
+		t.Fatal("valid notifiers were rejected")
+	}
+
+	error_output = io.Discard
+	defer func() { error_output = os.Stderr }()
+
+	for _, notifier := range []*Notifier{
+		{Kind: "pigeon"},
+		{Kind: NOTIFY_WEBHOOK},
+		{Kind: NOTIFY_SMTP, Server: "localhost:25"},
+		{Kind: NOTIFY_COMMAND, Command: "true", Events: []string{"order_finished"}},
+	} {
+		config.Notify = []*Notifier{notifier}
+
+		if check_notifiers(config) {
+			t.Errorf("%+v should have been rejected", notifier)
+		}
+	}
+}
@@ -24,7 +24,6 @@ import "fmt"
 import "time"
 import "sort"
 import "bytes"
-import "bufio"
 import "io/fs"
 import "strings"
 import "os/exec"
@@ -33,18 +32,50 @@ import "path/filepath"
 import "github.com/BurntSushi/toml"
 
 type Order struct {
-	lock string
+	lock *Lock
 
 	Name           string    `toml:"name"`
 	Blender_Target string    `toml:"blender_target"`
+	Preset         string    `toml:"preset"`
+	Scene          string    `toml:"scene"`
+	View_Layer     string    `toml:"view_layer"`
+	Camera         string    `toml:"camera"`
+	Priority       int       `toml:"priority"`
+	After          []string  `toml:"after"`
 	Time           time.Time `toml:"time"`
 
-	Start_Frame uint         `toml:"start_frame"`
-	End_Frame   uint         `toml:"end_frame"`
-	frame_count uint
+	Start_Frame int          `toml:"start_frame"`
+	End_Frame   int          `toml:"end_frame"`
+	Frame_Step  uint         `toml:"frame_step"`
+	Chunk_Size  uint         `toml:"chunk_size"`
+	Frames      string       `toml:"frames"`
+	frame_set   []int
+
+	// the frames a redo is rendering again, which leaves
+	// the order's own frames alone and is cleared once
+	// they're done
+	Redo_Frames string       `toml:"redo_frames,omitempty"`
+	redo_set    []int
 
 	Resolution_X uint        `toml:"resolution_y"`
 	Resolution_Y uint        `toml:"resolution_x"`
+	percentage   uint
+
+	Engine             string  `toml:"engine"`
+	Samples            uint    `toml:"samples"`
+	Adaptive_Threshold float64 `toml:"adaptive_threshold"`
+	Denoise            uint8   `toml:"denoise"`
+	Light_Bounces      *uint   `toml:"light_bounces,omitempty"`
+	Simplify           *uint   `toml:"simplify,omitempty"`
+	Motion_Blur        uint8   `toml:"motion_blur"`
+
+	File_Format string       `toml:"file_format"`
+	Color_Depth string       `toml:"color_depth"`
+	Codec       string       `toml:"codec"`
+	Frame_Rate  float64      `toml:"frame_rate"`
+
+	Encode  *Encode_Spec   `toml:"encode,omitempty"`
+	Encoded *Encode_Result `toml:"encoded,omitempty"`
 
 	Source_Path string       `toml:"source_path"`
 	Target_Path string       `toml:"target_path"`
@@ -53,9 +84,121 @@ type Order struct {
 	Overwrite        uint8   `toml:"overwrite"`
 	Use_Placeholders uint8   `toml:"use_placeholders"`
 
+	Retry           *Order_Retry `toml:"retry,omitempty"`
+	no_render_cache bool
+	no_retry        bool // the failure can't be fixed by trying again
+
+	Last_Frame   int         `toml:"last_frame"`
+	Has_Progress bool        `toml:"has_progress"`
+
+	Held          bool          `toml:"held"`
+	Status        Order_Status  `toml:"status"`
+	Attempts      uint          `toml:"attempts"`
+	Error_Kind    Blender_Error `toml:"error_kind"`
+	Error_Message string        `toml:"error_message"`
+	Error_Frame   *int          `toml:"error_frame,omitempty"`
+	Exit_Code     int           `toml:"exit_code"`
+
+	// only kept in sync with Status for the sake of
+	// older versions of Sous Chef sharing the queue
 	Complete  bool           `toml:"complete"`
 }
 
+type Order_Status uint8
+const (
+	STATUS_PENDING Order_Status = iota
+	STATUS_RENDERING
+	STATUS_FAILED
+	STATUS_COMPLETE
+)
+
+func (s Order_Status) String() string {
+	switch s {
+	case STATUS_RENDERING:
+		return "rendering"
+	case STATUS_FAILED:
+		return "failed"
+	case STATUS_COMPLETE:
+		return "complete"
+	}
+	return "pending"
+}
+
+func (s Order_Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Order_Status) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "", "pending":
+		*s = STATUS_PENDING
+	case "rendering":
+		*s = STATUS_RENDERING
+	case "failed":
+		*s = STATUS_FAILED
+	case "complete":
+		*s = STATUS_COMPLETE
+	default:
+		return fmt.Errorf("unknown order status %q", string(text))
+	}
+	return nil
+}
+
+func (order *Order) set_failed(kind Blender_Error, message string, exit_code int, frame *int) {
+	order.Status        = STATUS_FAILED
+	order.Error_Kind    = kind
+	order.Error_Message = message
+	order.Exit_Code     = exit_code
+	order.Error_Frame   = frame
+}
+
+func (order *Order) clear_error() {
+	order.Error_Kind    = ALL_GOOD
+	order.Error_Message = ""
+	order.Exit_Code     = 0
+	order.Error_Frame   = nil
+}
+
+// the status as the user should see it: an order can
+// claim to be rendering long after its machine died
+func order_state(config *Config, order *Order, queue Order_Array) string {
+	if order.Held && order.Status != STATUS_COMPLETE {
+		return "held"
+	}
+
+	if order.Status == STATUS_PENDING {
+		switch dependency_state(order, queue) {
+		case DEPENDENCIES_WAITING:
+			return "waiting"
+		case DEPENDENCIES_BLOCKED:
+			return "blocked"
+		}
+	}
+
+	if order.Status == STATUS_RENDERING {
+		if order.Chunk_Size > 0 {
+			for _, chunk := range load_chunks(config.project_dir, order) {
+				if chunk.lock != nil && !chunk.Complete && !chunk.lock.is_stale(config) {
+					return "rendering"
+				}
+			}
+			return "interrupted"
+		}
+
+		if order.lock == nil || order.lock.is_stale(config) {
+			return "interrupted"
+		}
+	}
+	return order.Status.String()
+}
+
+func print_failure(order *Order) {
+	eprintf(apply_color("[$1%s$0] failed: %s\n"), order.Name, order.Error_Kind.String())
+	if order.Error_Message != "" {
+		eprintf("    %s\n", order.Error_Message)
+	}
+}
+
 const (
 	UNSPECIFIED uint8 = iota
 	YES
@@ -74,29 +217,67 @@ func format_fallback_bool(value uint8) string {
 	return SET_BY_FILE
 }
 
+func parse_fallback_bool(value string) uint8 {
+	switch strings.ToLower(value) {
+	case "yes":
+		return YES
+	case "no":
+		return NO
+	}
+	return UNSPECIFIED
+}
+
 func command_order(config *Config, args *Arguments) {
+	create_orders(config, args)
+}
+
+// builds and saves one order, or one per scene with
+// --all-scenes, returning everything that was created
+func create_orders(config *Config, args *Arguments) ([]*Order, bool) {
 	if !file_exists(args.source_path) {
 		eprintf(apply_color("$1%q$0 does not exist.\n"), args.source_path)
-		return
+		return nil, false
 	}
 
 	if filepath.Ext(args.source_path) != ".blend" {
 		eprintf(apply_color("$1%q$0 is not a Blender file.\n"), args.source_path)
-		return
+		return nil, false
 	}
 
-	args.source_path, _ = filepath.Abs(args.source_path)
-	args.output_path, _ = filepath.Abs(args.output_path)
+	if args.all_scenes && (args.replace_id != "" || args.scene != "") {
+		eprintln("--all-scenes cannot be combined with --replace or --scene")
+		return nil, false
+	}
 
-	name := args.replace_id
-	if name == "" {
-		name = new_name(config.project_dir)
+	if !apply_preset(config, args) {
+		return nil, false
 	}
 
+	if !check_quality(args) {
+		return nil, false
+	}
+
+	args.source_path, _ = filepath.Abs(args.source_path)
+	args.output_path, _ = filepath.Abs(args.output_path)
+
 	the_order := new(Order)
 
-	the_order.Name = name
-	the_order.Time = time.Now()
+	the_order.Name     = args.replace_id
+	the_order.Time     = time.Now()
+	the_order.Preset   = args.preset
+	the_order.Priority = args.priority
+	the_order.After    = args.after
+
+	if len(the_order.After) > 0 {
+		queue, ok := load_orders(config.project_dir, false)
+		if !ok {
+			return nil, false
+		}
+
+		if !check_dependencies(the_order, queue) {
+			return nil, false
+		}
+	}
 
 	the_order.Source_Path = args.source_path
 	the_order.Output_Path = args.output_path
@@ -106,10 +287,46 @@ func command_order(config *Config, args *Arguments) {
 	the_order.Overwrite        = args.overwrite
 	the_order.Use_Placeholders = args.use_placeholders
 
+	the_order.View_Layer  = args.view_layer
+	the_order.Camera      = args.camera
+	the_order.Frame_Step  = args.frame_step
+	the_order.Chunk_Size  = args.chunk_size
+
+	if the_order.Chunk_Size == 0 {
+		the_order.Chunk_Size = config.Default_Chunk
+	}
+
+	the_order.Engine             = args.engine
+	the_order.Samples            = args.samples
+	the_order.Adaptive_Threshold = args.adaptive_threshold
+	the_order.Denoise            = args.denoise
+	the_order.Light_Bounces      = args.light_bounces
+	the_order.Simplify           = args.simplify
+	the_order.Motion_Blur        = args.motion_blur
+
+	if !resolve_format(the_order, args.file_format, args.output_path) {
+		return nil, false
+	}
+
+	if !apply_encode(config, the_order, args.encode) {
+		return nil, false
+	}
+
+	if args.retry_set || args.retry_delay > 0 {
+		the_order.Retry = &Order_Retry{
+			Delay: args.retry_delay,
+		}
+
+		if args.retry_set {
+			attempts := args.retry_attempts
+			the_order.Retry.Attempts = &attempts
+		}
+	}
+
 	if args.blender_target == "" {
 		if config.Default_Target == "" {
 			eprintln("No Blender target has been provided!")
-			return
+			return nil, false
 		}
 		the_order.Blender_Target = config.Default_Target
 	} else {
@@ -118,24 +335,117 @@ func command_order(config *Config, args *Arguments) {
 
 	printf("Gathering information from %s...", basename)
 
-	success := order_info(config, the_order)
+	info, success := blend_info(config, the_order)
 	if !success {
 		eprintf("Failed to gather information from %s!\n", basename)
-		return
+		return nil, false
 	}
 
 	printf(RESET_LINE)
 
-	if args.start_frame != 0 && args.end_frame != 0 {
-		the_order.Start_Frame = args.start_frame
-		the_order.End_Frame   = args.end_frame
+	scenes := info.Scenes
+
+	if !args.all_scenes {
+		name := args.scene
+		if name == "" {
+			name = info.Active
+		}
+
+		scene, ok := info.find_scene(name)
+		if !ok {
+			eprintf(apply_color("Scene $1%q$0 not found in %s, which has: %s\n"), name, basename, info.scene_names())
+			return nil, false
+		}
+
+		scenes = []*Scene_Info{scene}
 	}
 
-	the_order.frame_count = the_order.End_Frame - the_order.Start_Frame
+	if args.all_scenes {
+		// scenes without the view layer or camera that
+		// was asked for are left out, rather than
+		// stopping the rest from being ordered
+		kept := make([]*Scene_Info, 0, len(scenes))
+
+		for _, scene := range scenes {
+			if what := scene.missing(the_order); what != "" {
+				printf(apply_color("Skipping scene $1%s$0, which has no %s\n"), scene.Name, what)
+				continue
+			}
+			kept = append(kept, scene)
+		}
 
+		if len(kept) == 0 {
+			eprintf("No scenes in %s have the view layer and camera requested\n", basename)
+			return nil, false
+		}
+
+		scenes = kept
+	}
+
+	for _, scene := range scenes {
+		if !scene.validate(the_order) {
+			return nil, false
+		}
+	}
+
+	created := make([]*Order, 0, len(scenes))
+
+	for _, scene := range scenes {
+		scene_order := *the_order
+
+		// without --scene, the scene is left to whichever
+		// one was active when the file was last saved
+		if args.scene != "" || args.all_scenes {
+			scene_order.Scene = scene.Name
+		}
+
+		scene_order.Start_Frame  = scene.Start_Frame
+		scene_order.End_Frame    = scene.End_Frame
+		scene_order.Resolution_X = scene.Resolution_X
+		scene_order.Resolution_Y = scene.Resolution_Y
+		scene_order.percentage   = scene.Percentage
+		scene_order.Frame_Rate   = scene.Frame_Rate
+
+		if !finish_order(config, args, &scene_order, scene) {
+			return created, false
+		}
+
+		created = append(created, &scene_order)
+	}
+
+	return created, true
+}
+
+// applies the remaining overrides to an order that's
+// had its scene information filled in, then caches
+// and saves it into the queue
+func finish_order(config *Config, args *Arguments, the_order *Order, scene *Scene_Info) bool {
+	if the_order.Name == "" {
+		the_order.Name = new_name(config.project_dir)
+	}
+
+	basename := filepath.Base(the_order.Source_Path)
+
+	if args.frames != "" && !apply_frames(the_order, args.frames, scene) {
+		return false
+	}
+
+	// explicit resolutions are taken literally,
+	// unless a percentage was also requested
 	if args.resolution_x > 0 && args.resolution_y > 0 {
 		the_order.Resolution_X = args.resolution_x
 		the_order.Resolution_Y = args.resolution_y
+		the_order.percentage   = 100
+	}
+
+	if args.percentage > 0 {
+		the_order.percentage = args.percentage
+	}
+
+	if the_order.percentage > 0 {
+		m := float64(the_order.percentage) / 100
+		the_order.Resolution_X = uint(float64(the_order.Resolution_X) * m)
+		the_order.Resolution_Y = uint(float64(the_order.Resolution_Y) * m)
 	}
 
 	save_path := order_path(config.project_dir, the_order.Name)
@@ -143,7 +453,7 @@ func command_order(config *Config, args *Arguments) {
 	if args.bank_order {
 		if !args.is_bat_installed {
 			eprintln("BAT is not discoverable on path: the --cache flag will not work.")
-			return
+			return false
 		}
 
 		printf("Generating cached copy of %s with BAT...", basename)
@@ -153,13 +463,16 @@ func command_order(config *Config, args *Arguments) {
 		err := cmd.Start()
 		if err != nil {
 			eprintln("Failed to start BAT!")
-			return
+			return false
 		}
 
-		err = cmd.Wait()
+		config.outside_lock(func() {
+			err = cmd.Wait()
+		})
+
 		if err != nil {
 			eprintln("Failed to cache order using BAT!")
-			return
+			return false
 		}
 
 		printf(RESET_LINE)
@@ -181,85 +494,36 @@ func command_order(config *Config, args *Arguments) {
 	save_order(the_order, manifest_path(config.project_dir, the_order.Name))
 	printf(apply_color("[$1%s$0] %s"), the_order.Name, basename)
 
+	if the_order.Scene != "" {
+		printf(" | %s", the_order.Scene)
+	}
+
 	if args.bank_order {
 		if size, ok := dir_size(save_path); ok {
 			printf(" | %.2fMB cache size", size)
 		}
 	}
 	printf("\n")
-}
-
-// there should be better way to do this, but
-// reading Blender files reliably sucks
-func order_info(config *Config, order *Order) bool {
-	const expression = `import bpy
-s = bpy.context.scene
-print("sous_range", s.frame_start, s.frame_end)
-print("sous_res", s.render.resolution_x, s.render.resolution_y, s.render.resolution_percentage)`
 
-	blender_path, ok := get_blender_path(config, order.Blender_Target)
-	if !ok {
-		return false
-	}
-
-	cmd := exec.Command(blender_path, "-b", order.Source_Path, "--python-expr", expression)
+	return true
+}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		panic(err)
-	}
+// the first frame that still needs rendering,
+// which is past End_Frame if everything is done
+func (order *Order) resume_frame() int {
+	return resume_from(order.Start_Frame, order.Last_Frame, order.Has_Progress, order.Frame_Step)
+}
 
-	err = cmd.Start()
-	if err != nil {
-		panic(err)
+func resume_from(start, last int, has_progress bool, step uint) int {
+	if !has_progress {
+		return start
 	}
 
-	scanner := bufio.NewScanner(stdout)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		if strings.HasPrefix(line, "sous_range") {
-			line = strings.TrimSpace(line[10:])
-
-			part := strings.SplitN(line, " ", 2)
-
-			if x, ok := parse_uint(part[0]); ok {
-				order.Start_Frame = x
-			}
-			if x, ok := parse_uint(part[1]); ok {
-				order.End_Frame = x
-			}
-		}
-
-		if strings.HasPrefix(line, "sous_res") {
-			line = strings.TrimSpace(line[8:])
-
-			part := strings.SplitN(line, " ", 3)
-
-			if x, ok := parse_uint(part[0]); ok {
-				order.Resolution_X = x
-			}
-			if y, ok := parse_uint(part[1]); ok {
-				order.Resolution_Y = y
-			}
-
-			percentage, ok := parse_uint(part[2])
-			if ok {
-				m := float64(percentage) / 100
-				order.Resolution_X = uint(float64(order.Resolution_X) * m)
-				order.Resolution_Y = uint(float64(order.Resolution_Y) * m)
-			}
-
-			// @error needed here if we can't parse.
-			// I've tried hundreds of files and it's
-			// not happened yet so I feel pretty safe
-			// in being nasty and leaving it.
-		}
+	if step == 0 {
+		step = 1
 	}
 
-	cmd.Wait()
-	return true
+	return last + int(step)
 }
 
 type Order_Array []*Order
@@ -267,7 +531,11 @@ type Order_Array []*Order
 func (orders Order_Array) Len() int {
 	return len(orders)
 }
+// higher priorities go first, then oldest first
 func (orders Order_Array) Less(i, j int) bool {
+	if orders[i].Priority != orders[j].Priority {
+		return orders[i].Priority > orders[j].Priority
+	}
 	return orders[i].Time.Before(orders[j].Time)
 }
 func (orders Order_Array) Swap(i, j int) {
@@ -279,6 +547,8 @@ func (orders Order_Array) Swap(i, j int) {
 }*/
 
 func save_order(order *Order, file_path string) bool {
+	order.Complete = order.Status == STATUS_COMPLETE
+
 	buffer := bytes.Buffer{}
 	buffer.Grow(512)
 
@@ -287,7 +557,26 @@ func save_order(order *Order, file_path string) bool {
 		return false
 	}
 
-	if err := os.WriteFile(file_path, buffer.Bytes(), 0777); err != nil {
+	// manifests are re-read by other commands and machines
+	// while they're being changed, so a new one is written
+	// alongside and swapped in, rather than ever leaving
+	// a half-written file in place
+	temp, err := os.CreateTemp(filepath.Dir(file_path), MANIFEST_NAME + ".*")
+	if err != nil {
+		fmt.Println(err)
+		eprintln("Failed to write order file")
+		return false
+	}
+
+	_, err = temp.Write(buffer.Bytes())
+	temp.Close()
+
+	if err == nil {
+		err = os.Rename(temp.Name(), file_path)
+	}
+
+	if err != nil {
+		os.Remove(temp.Name())
 		fmt.Println(err)
 		eprintln("Failed to write order file")
 		return false
@@ -296,6 +585,36 @@ func save_order(order *Order, file_path string) bool {
 	return true
 }
 
+// changes the manifest as it is on disk right now, rather
+// than writing back a copy that was loaded who knows how
+// long ago, so that holds, moves and rendering progress
+// from elsewhere aren't quietly undone
+func update_order(project_dir, name string, change func(*Order)) bool {
+	file_path := manifest_path(project_dir, name)
+
+	current, ok := load_order(file_path)
+	if !ok {
+		eprintf(apply_color("Order $1%q$0 does not exist\n"), name)
+		return false
+	}
+
+	change(current)
+	return save_order(current, file_path)
+}
+
+// everything a render changes about an order
+func (order *Order) copy_run_state(from *Order) {
+	order.Status        = from.Status
+	order.Attempts      = from.Attempts
+	order.Last_Frame    = from.Last_Frame
+	order.Has_Progress  = from.Has_Progress
+	order.Redo_Frames   = from.Redo_Frames
+	order.Error_Kind    = from.Error_Kind
+	order.Error_Message = from.Error_Message
+	order.Exit_Code     = from.Exit_Code
+	order.Error_Frame   = from.Error_Frame
+}
+
 func load_order(path string) (*Order, bool) {
 	blob, ok := load_file(path)
 	if !ok {
@@ -310,7 +629,17 @@ func load_order(path string) (*Order, bool) {
 		return nil, false
 	}
 
-	data.frame_count = data.End_Frame - data.Start_Frame
+	// manifests from before order statuses
+	// existed only had the complete flag
+	if data.Complete && data.Status == STATUS_PENDING {
+		data.Status = STATUS_COMPLETE
+	}
+
+	// and ones from before error frames were optional
+	// wrote a zero whether there was an error or not
+	if data.Error_Frame != nil && *data.Error_Frame == 0 && data.Error_Kind == ALL_GOOD {
+		data.Error_Frame = nil
+	}
 
 	return data, true
 }
@@ -318,6 +647,7 @@ func load_order(path string) (*Order, bool) {
 func load_orders(root string, shallow bool) ([]*Order, bool) {
 	order_list := make(Order_Array, 0, 16)
 
+	project_dir := root
 	root = filepath.Join(root, ORDER_DIR)
 
 	first := true
@@ -341,9 +671,7 @@ func load_orders(root string, shallow bool) ([]*Order, bool) {
 				return nil
 			}
 
-			path = filepath.Join(path, MANIFEST_NAME)
-
-			the_order, ok := load_order(path)
+			the_order, ok := load_order(filepath.Join(path, MANIFEST_NAME))
 			if !ok {
 				// yeah we just have to return _some_ error.
 				// unfortunately, Go doesn't provide us with
@@ -351,11 +679,12 @@ func load_orders(root string, shallow bool) ([]*Order, bool) {
 				return io.EOF
 			}
 
-			blob, ok := load_file(filepath.Join(path, LOCK_NAME))
-			if ok {
-				the_order.lock = strings.TrimSpace(blob)
+			if lock, ok := read_lock(filepath.Join(path, LOCK_NAME)); ok {
+				the_order.lock = lock
 			}
 
+			the_order.apply_chunk_state(project_dir)
+
 			order_list = append(order_list, the_order)
 
 			return filepath.SkipDir
@@ -0,0 +1,123 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "strings"
+
+// a preset is a labelled block of order settings
+// in config.toml, selected with --preset.  every
+// field is optional and only fills in whatever
+// the user didn't explicitly supply on the CLI
+type Preset struct {
+	Name         string  `toml:"name"`
+	Resolution   string  `toml:"resolution"`
+	Percentage   uint    `toml:"percentage"`
+	Frame_Step   uint    `toml:"frame_step"`
+	Samples      uint    `toml:"samples"`
+	Engine       string  `toml:"engine"`
+	Adaptive     float64 `toml:"adaptive_threshold"`
+	Denoise      string  `toml:"denoise"`
+	Bounces      *uint   `toml:"light_bounces"`
+	Simplify     *uint   `toml:"simplify"`
+	Motion_Blur  string  `toml:"motion_blur"`
+	Format       string  `toml:"format"`
+	Encode       string  `toml:"encode"`
+	Placeholders string  `toml:"placeholders"`
+	Overwrite    string  `toml:"overwrite"`
+}
+
+func get_preset(config *Config, name string) (*Preset, bool) {
+	for _, preset := range config.Presets {
+		if strings.EqualFold(preset.Name, name) {
+			return preset, true
+		}
+	}
+	return nil, false
+}
+
+func apply_preset(config *Config, args *Arguments) bool {
+	if args.preset == "" {
+		return true
+	}
+
+	preset, ok := get_preset(config, args.preset)
+	if !ok {
+		eprintf(apply_color("Preset $1%q$0 not in config.toml\n"), args.preset)
+		return false
+	}
+
+	args.preset = preset.Name
+
+	// a percentage of an explicit resolution would
+	// quietly turn it into a different one, but the
+	// preset's own resolution is fair game
+	has_resolution := args.resolution_x > 0 || args.resolution_y > 0
+
+	if !has_resolution && preset.Resolution != "" {
+		x, y, ok := parse_resolution(preset.Resolution)
+		if !ok {
+			eprintf(apply_color("Preset $1%q$0 has an invalid resolution %q\n"), preset.Name, preset.Resolution)
+			return false
+		}
+		args.resolution_x = x
+		args.resolution_y = y
+	}
+
+	if args.percentage == 0 && !has_resolution {
+		args.percentage = preset.Percentage
+	}
+	if args.frame_step == 0 {
+		args.frame_step = preset.Frame_Step
+	}
+	if args.samples == 0 {
+		args.samples = preset.Samples
+	}
+	if args.engine == "" {
+		args.engine = preset.Engine
+	}
+	if args.adaptive_threshold == 0 {
+		args.adaptive_threshold = preset.Adaptive
+	}
+	if args.denoise == UNSPECIFIED {
+		args.denoise = parse_fallback_bool(preset.Denoise)
+	}
+	if args.light_bounces == nil {
+		args.light_bounces = preset.Bounces
+	}
+	if args.simplify == nil {
+		args.simplify = preset.Simplify
+	}
+	if args.motion_blur == UNSPECIFIED {
+		args.motion_blur = parse_fallback_bool(preset.Motion_Blur)
+	}
+	if args.file_format == "" {
+		args.file_format = preset.Format
+	}
+	if args.encode == "" {
+		args.encode = preset.Encode
+	}
+	if args.use_placeholders == UNSPECIFIED {
+		args.use_placeholders = parse_fallback_bool(preset.Placeholders)
+	}
+	if args.overwrite == UNSPECIFIED {
+		args.overwrite = parse_fallback_bool(preset.Overwrite)
+	}
+
+	return true
+}
@@ -0,0 +1,84 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "testing"
+
+func preset_config() *Config {
+	return &Config{
+		Presets: []*Preset{{
+			Name:       "previz",
+			Resolution: "hd",
+			Percentage: 50,
+			Samples:    16,
+		}},
+	}
+}
+
+func TestPresetPercentage(t *testing.T) {
+	args := &Arguments{preset: "previz"}
+
+	if !apply_preset(preset_config(), args) {
+		t.Fatal("the preset wasn't applied")
+	}
+
+	if args.resolution_x != 1920 || args.resolution_y != 1080 {
+		t.Errorf("resolution is %dx%d", args.resolution_x, args.resolution_y)
+	}
+	if args.percentage != 50 {
+		t.Errorf("the preset's own resolution dropped its percentage: %d", args.percentage)
+	}
+	if args.samples != 16 {
+		t.Errorf("samples are %d", args.samples)
+	}
+}
+
+func TestPresetExplicitResolution(t *testing.T) {
+	args := &Arguments{
+		preset:       "previz",
+		resolution_x: 1280,
+		resolution_y: 720,
+	}
+
+	if !apply_preset(preset_config(), args) {
+		t.Fatal("the preset wasn't applied")
+	}
+
+	if args.resolution_x != 1280 || args.resolution_y != 720 {
+		t.Errorf("resolution is %dx%d", args.resolution_x, args.resolution_y)
+	}
+	if args.percentage != 0 {
+		t.Errorf("an explicit resolution was scaled by %d%%", args.percentage)
+	}
+}
+
+func TestPresetExplicitPercentage(t *testing.T) {
+	args := &Arguments{
+		preset:     "previz",
+		percentage: 25,
+	}
+
+	if !apply_preset(preset_config(), args) {
+		t.Fatal("the preset wasn't applied")
+	}
+
+	if args.percentage != 25 {
+		t.Errorf("percentage is %d", args.percentage)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "fmt"
+import "strconv"
+import "strings"
+
+const (
+	ENGINE_CYCLES    = "CYCLES"
+	ENGINE_EEVEE     = "BLENDER_EEVEE"
+	ENGINE_WORKBENCH = "BLENDER_WORKBENCH"
+)
+
+// Blender caps the simplify subdivision level at 6
+const MAX_SIMPLIFY = 6
+
+func parse_engine(name string) (string, bool) {
+	switch strings.ToLower(name) {
+	case "cycles":
+		return ENGINE_CYCLES, true
+	case "eevee", "blender_eevee", "blender_eevee_next":
+		return ENGINE_EEVEE, true
+	case "workbench", "blender_workbench":
+		return ENGINE_WORKBENCH, true
+	}
+	return "", false
+}
+
+func parse_float(str string) (float64, bool) {
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// the quality overrides are all validated together,
+// whether they came from the CLI or a preset
+func check_quality(args *Arguments) bool {
+	if args.engine != "" {
+		engine, ok := parse_engine(args.engine)
+		if !ok {
+			eprintf(apply_color("Engine $1%q$0 should be one of cycles, eevee or workbench\n"), args.engine)
+			return false
+		}
+		args.engine = engine
+	}
+
+	if args.adaptive_threshold < 0 {
+		eprintln("Adaptive threshold cannot be negative")
+		return false
+	}
+
+	if args.simplify != nil && *args.simplify > MAX_SIMPLIFY {
+		eprintf("Simplify level must be between 0 and %d\n", MAX_SIMPLIFY)
+		return false
+	}
+
+	return true
+}
+
+func inject_quality(buffer *strings.Builder, order *Order) {
+	switch order.Engine {
+	case "":
+	case ENGINE_EEVEE:
+		// Eevee Next took over the engine's identifier
+		// for a few versions, so try that first
+		buffer.WriteString("try:\n")
+		buffer.WriteString("    bpy.context.scene.render.engine = 'BLENDER_EEVEE_NEXT'\n")
+		buffer.WriteString("except TypeError:\n")
+		buffer.WriteString("    bpy.context.scene.render.engine = 'BLENDER_EEVEE'\n")
+	default:
+		buffer.WriteString(fmt.Sprintf("bpy.context.scene.render.engine = %q\n", order.Engine))
+	}
+
+	if order.Adaptive_Threshold > 0 {
+		buffer.WriteString("bpy.context.scene.cycles.use_adaptive_sampling = True\n")
+		buffer.WriteString(fmt.Sprintf("bpy.context.scene.cycles.adaptive_threshold = %g\n", order.Adaptive_Threshold))
+	}
+
+	if order.Denoise != UNSPECIFIED {
+		buffer.WriteString(fmt.Sprintf("bpy.context.scene.cycles.use_denoising = %s\n", python_bool(order.Denoise)))
+	}
+
+	if order.Light_Bounces != nil {
+		buffer.WriteString(fmt.Sprintf("bpy.context.scene.cycles.max_bounces = %d\n", *order.Light_Bounces))
+	}
+
+	if order.Simplify != nil {
+		buffer.WriteString("bpy.context.scene.render.use_simplify = True\n")
+		buffer.WriteString(fmt.Sprintf("bpy.context.scene.render.simplify_subdivision_render = %d\n", *order.Simplify))
+	}
+
+	if order.Motion_Blur != UNSPECIFIED {
+		buffer.WriteString(fmt.Sprintf("bpy.context.scene.render.use_motion_blur = %s\n", python_bool(order.Motion_Blur)))
+	}
+}
+
+func python_bool(value uint8) string {
+	if value == YES {
+		return "True"
+	}
+	return "False"
+}
+
+func print_quality(order *Order) {
+	if order.Engine != "" {
+		printf("   Engine:       %s\n", order.Engine)
+	}
+
+	if order.Samples > 0 {
+		printf("   Samples:      %d\n", order.Samples)
+	}
+
+	if order.Adaptive_Threshold > 0 {
+		printf("   Adaptive:     %g\n", order.Adaptive_Threshold)
+	}
+
+	if order.Denoise != UNSPECIFIED {
+		printf("   Denoise:      %s\n", format_fallback_bool(order.Denoise))
+	}
+
+	if order.Light_Bounces != nil {
+		printf("   Bounces:      %d\n", *order.Light_Bounces)
+	}
+
+	if order.Simplify != nil {
+		printf("   Simplify:     %d\n", *order.Simplify)
+	}
+
+	if order.Motion_Blur != UNSPECIFIED {
+		printf("   Motion Blur:  %s\n", format_fallback_bool(order.Motion_Blur))
+	}
+}
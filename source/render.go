@@ -18,8 +18,8 @@
 
 package main
 
-import "os"
 import "fmt"
+import "sync"
 import "time"
 import "bufio"
 import "os/exec"
@@ -39,6 +39,7 @@ const (
 	RENDERER_KERNEL_FAIL
 	GPU_NOT_SUPPORTED
 	EXCEPTION_ACCESS_VIOLATION
+	UNKNOWN_ERROR
 )
 
 func (e Blender_Error) String() string {
@@ -65,10 +66,78 @@ func (e Blender_Error) String() string {
 		// searching themselves — it could be drivers, display
 		// properties and (notably) some weird Windows quirks
 		return "EXCEPTION_ACCESS_VIOLATION"
+	case UNKNOWN_ERROR:
+		return "Blender exited unexpectedly"
 	}
 	return "" // never happens
 }
 
+// the constant's own name, which is what gets
+// written into manifests and the config
+func (e Blender_Error) Name() string {
+	switch e {
+	case NO_MEMORY:
+		return "NO_MEMORY"
+	case NO_VIDEO_MEMORY:
+		return "NO_VIDEO_MEMORY"
+	case FILESYSTEM_ERROR:
+		return "FILESYSTEM_ERROR"
+	case PYTHON_FAIL:
+		return "PYTHON_FAIL"
+	case RENDERER_CRASH:
+		return "RENDERER_CRASH"
+	case RENDERER_NOT_SUPPORTED:
+		return "RENDERER_NOT_SUPPORTED"
+	case RENDERER_KERNEL_FAIL:
+		return "RENDERER_KERNEL_FAIL"
+	case GPU_NOT_SUPPORTED:
+		return "GPU_NOT_SUPPORTED"
+	case EXCEPTION_ACCESS_VIOLATION:
+		return "EXCEPTION_ACCESS_VIOLATION"
+	case UNKNOWN_ERROR:
+		return "UNKNOWN_ERROR"
+	}
+	return ""
+}
+
+func parse_blender_error(name string) (Blender_Error, bool) {
+	for e := ALL_GOOD + 1; e <= UNKNOWN_ERROR; e++ {
+		if strings.EqualFold(e.Name(), name) {
+			return e, true
+		}
+	}
+	return ALL_GOOD, name == ""
+}
+
+func (e Blender_Error) MarshalText() ([]byte, error) {
+	return []byte(e.Name()), nil
+}
+
+func (e *Blender_Error) UnmarshalText(text []byte) error {
+	value, ok := parse_blender_error(string(text))
+	if !ok {
+		return fmt.Errorf("unknown error kind %q", string(text))
+	}
+	*e = value
+	return nil
+}
+
+// pulls the frame number out of Blender's
+// "Fra:12 Mem:..." status lines
+func parse_frame_line(input string) (int, bool) {
+	if !strings.HasPrefix(input, "Fra:") {
+		return 0, false
+	}
+
+	for i, c := range input {
+		if unicode.IsSpace(c) {
+			return parse_int(input[4:i])
+		}
+	}
+
+	return 0, false
+}
+
 func check_progress(order *Order, input string) string {
 	buffer := strings.Builder{}
 
@@ -79,9 +148,9 @@ func check_progress(order *Order, input string) string {
 			if unicode.IsSpace(c) {
 				the_frame := input[4:i]
 
-				percentage, ok := parse_uint(the_frame)
-				if ok {
-					percentage = uint(float64(percentage - order.Start_Frame) / float64(order.frame_count) * 100)
+				percentage := uint(0)
+				if frame, ok := parse_int(the_frame); ok {
+					percentage = order.frame_percentage(frame)
 				}
 
 				buffer.WriteString(fmt.Sprintf("| %d%% %s ", percentage, the_frame))
@@ -154,91 +223,417 @@ func command_render(config *Config, args *Arguments) {
 		return
 	}
 
-	for len(queue) > 0 {
-		the_order := queue[0]
+	// the queue is reloaded after every order, so that
+	// dependents can start as soon as whatever they're
+	// waiting on is done, and so that new orders or
+	// changes from other machines are picked up
+	attempted := make(map[string]bool, len(queue))
 
-		if the_order.Complete {
-			queue = queue[1:]
-			continue
+	started  := false
+	rendered := 0
+	failed   := 0
+
+	var queue_started time.Time
+
+	for {
+		the_order, ok := next_order(config, args, queue, attempted)
+		if !ok {
+			break
+		}
+
+		// the queue hooks only run if this machine
+		// actually has something to do
+		if !started {
+			run_hook(config, HOOK_QUEUE_START, nil)
+			started       = true
+			queue_started = time.Now()
+		}
+
+		attempted[the_order.Name] = true
+
+		// worked out once per order, rather than on every
+		// run of Blender, as it reads the whole queue
+		estimate := estimate_queue(config, queue)
+
+		switch render_order(config, the_order, estimate) {
+		case RENDER_DONE:
+			rendered++
+		case RENDER_FAILED:
+			failed++
+		}
+
+		queue, ok = load_orders(config.project_dir, false)
+		if !ok {
+			break
 		}
+	}
+
+	if started {
+		run_hook(config, HOOK_QUEUE_DRAIN, nil,
+			fmt.Sprintf("SOUS_RENDERED=%d", rendered),
+			fmt.Sprintf("SOUS_FAILED=%d", failed),
+		)
+		notify(config, queue_notice(config, time.Since(queue_started), rendered, failed))
+	}
+}
 
-		if the_order.lock != "" && the_order.lock != config.own_hostname {
-			queue = queue[1:]
+func next_order(config *Config, args *Arguments, queue Order_Array, attempted map[string]bool) (*Order, bool) {
+	for _, order := range queue {
+		if attempted[order.Name] {
 			continue
 		}
 
-		lock_file := lock_path(config.project_dir, the_order.Name)
-		write_file(lock_file, config.own_hostname)
+		if order.Status == STATUS_COMPLETE || order.Held {
+			continue
+		}
 
-		did_run := run_order(config, the_order)
-		if !did_run {
-			println("Failed!")
-			queue = queue[1:]
+		if order.Status == STATUS_FAILED && !args.retry_failed {
 			continue
 		}
 
-		os.Remove(lock_file)
+		if dependency_state(order, queue) != DEPENDENCIES_READY {
+			continue
+		}
 
-		did_save := save_order(the_order, manifest_path(config.project_dir, the_order.Name))
-		if !did_save {
-			print("\n") // preserve the error emitted by save_order
-			queue = queue[1:]
+		if order.Chunk_Size == 0 && !can_claim(config, order.lock) {
 			continue
 		}
 
-		queue = queue[1:]
+		return order, true
+	}
+
+	return nil, false
+}
+
+// anything that runs once an order is complete; its
+// failures are recorded separately and never turn a
+// finished render into a failed one
+func post_render(config *Config, order *Order) {
+	if order.Status != STATUS_COMPLETE {
+		return
+	}
+
+	if config.Contact.After_Render {
+		make_contact_sheet(config, order, &config.Contact)
+	}
+
+	if order.Encode != nil {
+		encode_order(config, order)
+	}
+}
+
+type Render_Result uint8
+const (
+	RENDER_SKIPPED Render_Result = iota // someone else got there first
+	RENDER_DONE
+	RENDER_FAILED
+)
+
+func render_order(config *Config, the_order *Order, estimate *Queue_Estimate) Render_Result {
+	if the_order.Chunk_Size > 0 {
+		return render_chunks(config, the_order, estimate)
+	}
+
+	lock_file := lock_path(config.project_dir, the_order.Name)
+	old_lock  := the_order.lock
+
+	lock, ok := claim_lock(config, lock_file)
+	if !ok {
+		return RENDER_SKIPPED
 	}
+
+	the_order.lock = lock
+
+	if old_lock != nil && old_lock.is_stale(config) {
+		notify(config, stale_notice(config, the_order, nil, old_lock))
+	}
+
+	run_hook(config, HOOK_BEFORE_ORDER, the_order)
+
+	started := time.Now()
+	did_run := run_with_retries(config, the_order, nil, estimate)
+
+	if lock.is_lost() {
+		return RENDER_SKIPPED
+	}
+
+	if !did_run {
+		release_lock(lock_file, lock)
+		run_hook(config, HOOK_ORDER_FAILURE, the_order)
+		notify(config, order_notice(config, NOTIFY_ORDER_FAILED, the_order, time.Since(started)))
+		return RENDER_FAILED
+	}
+
+	// saved as complete before the lock goes,
+	// or someone could pick it up in between
+	did_save := save_run_state(config, the_order, nil)
+	if !did_save {
+		print("\n") // preserve the error emitted by save_order
+	}
+
+	release_lock(lock_file, lock)
+
+	post_render(config, the_order)
+
+	run_hook(config, HOOK_ORDER_SUCCESS, the_order)
+	notify(config, order_notice(config, NOTIFY_ORDER_COMPLETE, the_order, time.Since(started)))
+	return RENDER_DONE
 }
 
-func run_order(config *Config, order *Order) bool {
+// renders either the whole order or, if chunk is
+// non-nil, just the frames belonging to that chunk
+func run_order(config *Config, order *Order, chunk *Chunk, estimate *Queue_Estimate) bool {
 	blender_path, got_path := get_blender_path(config, order.Blender_Target)
 	if !got_path {
+		order.set_failed(UNKNOWN_ERROR, "Blender target not found", 0, nil)
+		order.no_retry = true
+		save_run_state(config, order, chunk)
 		return false
 	}
 
+	start_frame, end_frame := order.resume_frame(), order.End_Frame
+	has_progress := order.Has_Progress
+
+	if chunk != nil {
+		start_frame, end_frame = chunk.resume_frame(order.Frame_Step), chunk.End_Frame
+		has_progress = chunk.Has_Progress
+	}
+
+	// discontiguous orders render only the listed
+	// frames that are left within this span
+	remaining := order.frames_between(start_frame, end_frame)
+
+	if start_frame > end_frame || len(remaining) == 0 {
+		order.clear_error()
+
+		if chunk != nil {
+			chunk.Complete = true
+			chunk.copy_error(order)
+		} else {
+			order.Status = STATUS_COMPLETE
+			order.clear_redo()
+		}
+		printf(apply_color("[$1%s$0] %s already rendered ✓\n"), order.Name, filepath.Base(order.Target_Path))
+		return true
+	}
+
+	if has_progress {
+		printf(apply_color("[$1%s$0] resuming from frame %d\n"), order.Name, start_frame)
+	}
+
+	order.Status    = STATUS_RENDERING
+	order.Attempts += 1
+
+	if chunk != nil {
+		chunk.Attempts += 1
+	}
+
+	save_run_state(config, order, chunk)
+
 	target := filepath.Join(config.project_dir, order.Target_Path)
 
-	// output    := filepath.Join(project_dir, order.Output_Path)      "-o"
-	// format, _ := get_image_types(filepath.Ext(order.Output_Path))   "-F"
+	arguments := []string{"-b", target}
+
+	// the scene has to be switched before the python
+	// runs, so that bpy.context.scene points at it
+	if order.Scene != "" {
+		arguments = append(arguments, "-S", order.Scene)
+	}
+
+	arguments = append(arguments, "--python-expr", inject(config.project_dir, order, start_frame, end_frame))
+
+	if order.is_frame_list() {
+		arguments = append(arguments, "-f", blender_frames(remaining, start_frame))
+	} else {
+		arguments = append(arguments, "-a")
+	}
 
-	the_command := exec.Command(blender_path, "-b", target, "--python-expr", inject(config.project_dir, order), "-a")
+	the_command := exec.Command(blender_path, arguments...)
 
 	stdout, err := the_command.StdoutPipe()
 	if err != nil {
+		order.set_failed(UNKNOWN_ERROR, err.Error(), 0, nil)
+		save_run_state(config, order, chunk)
+		return false
+	}
+
+	stderr, err := the_command.StderrPipe()
+	if err != nil {
+		order.set_failed(UNKNOWN_ERROR, err.Error(), 0, nil)
+		save_run_state(config, order, chunk)
 		return false
 	}
 
+	log, _ := create_log(config, order, chunk)
+	defer log.close()
+
 	err = the_command.Start()
 	if err != nil {
+		order.set_failed(UNKNOWN_ERROR, err.Error(), 0, nil)
+		save_run_state(config, order, chunk)
 		return false
 	}
 
+	// the first error spotted on either stream is the one
+	// we keep; Blender is killed straight away because
+	// most of these leave it hanging or limping along
+	failure      := ALL_GOOD
+	failure_line := ""
+	failure_once := sync.Once{}
+
+	report := func(state Blender_Error, line string) {
+		failure_once.Do(func() {
+			failure, failure_line = state, line
+			eprintln("error", state.String())
+			the_command.Process.Kill()
+		})
+	}
+
+	stderr_done := make(chan bool)
+
 	go func() {
-		time.Sleep(time.Second)
+		defer close(stderr_done)
 
-		scanner := bufio.NewScanner(stdout)
+		scanner := bufio.NewScanner(stderr)
 
 		for scanner.Scan() {
 			line := scanner.Text()
-
-			message := check_progress(order, line)
-			printf(apply_color(RESET_LINE + "[$1%s$0] %s %s"), order.Name, filepath.Base(order.Target_Path), message)
+			log.write(line)
 
 			program_state := check_errors(line)
 			if program_state != ALL_GOOD {
-				eprintln("error", program_state.String())
-				break
+				report(program_state, line)
 			}
 		}
 	}()
 
+	lock, lock_file := active_lock(config, order, chunk)
+
+	// if another machine has taken the lock over, it's
+	// rendering these frames now, so Blender is stopped
+	stand_down := func() {
+		the_command.Process.Kill()
+	}
+
+	if lock != nil {
+		if !lock.start_run(lock_file, len(remaining)) {
+			stand_down()
+		}
+
+		stop_heartbeat := start_heartbeat(lock_file, lock, stand_down)
+		defer stop_heartbeat()
+	}
+
+	tracker := start_tracking(config, order, estimate)
+	defer tracker.finish()
+
+	// Blender prints "Fra:" for every pass over a frame,
+	// but only prints "Saved:" once that frame is safely
+	// on disk, so that's what we record as progress
+	current_frame := 0
+	seen_frame    := false
+
+	// once an error has turned up we stop reporting
+	// progress, but keep reading so the log is whole
+	failed := false
+
+	scanner := bufio.NewScanner(stdout)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		log.write(line)
+
+		if failed {
+			continue
+		}
+
+		if frame, ok := parse_frame_line(line); ok {
+			current_frame = frame
+			seen_frame    = true
+
+			if lock != nil && !lock.set_progress(lock_file, frame, false) {
+				stand_down()
+				failed = true
+				continue
+			}
+		}
+
+		if strings.HasPrefix(line, "Saved:") && seen_frame && current_frame >= start_frame {
+			if lock != nil && !lock.set_progress(lock_file, current_frame, true) {
+				stand_down()
+				failed = true
+				continue
+			}
+
+			if chunk != nil {
+				chunk.Last_Frame   = current_frame
+				chunk.Has_Progress = true
+				save_chunk(config.project_dir, order, chunk)
+			} else {
+				order.Last_Frame   = current_frame
+				order.Has_Progress = true
+				save_run_state(config, order, nil)
+			}
+		}
+
+		tracker.line(line)
+
+		message := check_progress(order, line)
+		if message != "" {
+			message += tracker.eta_message()
+		}
+		printf(apply_color(RESET_LINE + "[$1%s$0] %s %s"), order.Name, filepath.Base(order.Target_Path), message)
+
+		program_state := check_errors(line)
+		if program_state != ALL_GOOD {
+			report(program_state, line)
+			failed = true
+		}
+	}
+
+	<-stderr_done
+
 	err = the_command.Wait()
 
-	if err != nil {
+	// the order belongs to someone else now, so
+	// nothing about this run is ours to record
+	if lock.is_lost() {
+		printf("\n")
+		eprintf(apply_color("[$1%s$0] lock was taken over by another machine, stopping\n"), order.Name)
+		return false
+	}
+
+	if err != nil || failure != ALL_GOOD {
+		printf("\n")
+
+		exit_code := 0
+		if exit_error, ok := err.(*exec.ExitError); ok {
+			exit_code = exit_error.ExitCode()
+		}
+
+		if failure == ALL_GOOD {
+			failure, failure_line = UNKNOWN_ERROR, err.Error()
+		}
+
+		var error_frame *int
+		if seen_frame {
+			error_frame = &current_frame
+		}
+
+		order.set_failed(failure, strings.TrimSpace(failure_line), exit_code, error_frame)
+		save_run_state(config, order, chunk)
 		return false
 	}
 
-	order.Complete = true
+	order.clear_error()
+
+	if chunk != nil {
+		chunk.Complete = true
+		chunk.copy_error(order)
+	} else {
+		order.Status = STATUS_COMPLETE
+		order.clear_redo()
+	}
 	printf(" ✓\n")
 
 	return true
@@ -295,7 +690,7 @@ else:
 	bpy.context.scene.render.filepath = output_path
 `
 
-func inject(project_dir string, order *Order) string {
+func inject(project_dir string, order *Order, start_frame, end_frame int) string {
 	buffer := new(strings.Builder)
 	buffer.Grow(512)
 
@@ -306,13 +701,30 @@ func inject(project_dir string, order *Order) string {
 		buffer.WriteString(fmt.Sprintf(PATH_REWRITER, path))
 	}
 
+	if order.View_Layer != "" {
+		buffer.WriteString("for layer in bpy.context.scene.view_layers:\n")
+		buffer.WriteString(fmt.Sprintf("    layer.use = (layer.name == %q)\n", order.View_Layer))
+	}
+
+	if order.Camera != "" {
+		buffer.WriteString(fmt.Sprintf("bpy.context.scene.camera = bpy.data.objects[%q]\n", order.Camera))
+	}
+
 	// auto-tiling for Blender 3+
 	buffer.WriteString("bpy.context.scene.cycles.use_auto_tile = (bpy.app.version[0] < 3)\n")
 
-	buffer.WriteString("bpy.context.scene.render.use_render_cache = True\n")
+	if order.no_render_cache {
+		buffer.WriteString("bpy.context.scene.render.use_render_cache = False\n")
+	} else {
+		buffer.WriteString("bpy.context.scene.render.use_render_cache = True\n")
+	}
+
+	buffer.WriteString(fmt.Sprintf("bpy.context.scene.frame_start = %d\n", start_frame))
+	buffer.WriteString(fmt.Sprintf("bpy.context.scene.frame_end   = %d\n", end_frame))
 
-	buffer.WriteString(fmt.Sprintf("bpy.context.scene.frame_start = %d\n", order.Start_Frame))
-	buffer.WriteString(fmt.Sprintf("bpy.context.scene.frame_end   = %d\n", order.End_Frame))
+	if order.Frame_Step > 0 {
+		buffer.WriteString(fmt.Sprintf("bpy.context.scene.frame_step  = %d\n", order.Frame_Step))
+	}
 
 	if order.Resolution_X > 0 && order.Resolution_Y > 0 {
 		buffer.WriteString(fmt.Sprintf("bpy.context.scene.render.resolution_x = %d\n", order.Resolution_X))
@@ -320,6 +732,14 @@ func inject(project_dir string, order *Order) string {
 		buffer.WriteString("bpy.context.scene.render.resolution_percentage = 100\n")
 	}
 
+	if order.Samples > 0 {
+		buffer.WriteString(fmt.Sprintf("bpy.context.scene.cycles.samples = %d\n", order.Samples))
+		buffer.WriteString(fmt.Sprintf("bpy.context.scene.eevee.taa_render_samples = %d\n", order.Samples))
+	}
+
+	inject_quality(buffer, order)
+	inject_format(buffer, order)
+
 	if order.Use_Placeholders != UNSPECIFIED {
 		buffer.WriteString("bpy.context.scene.render.use_placeholder = ")
 		if order.Use_Placeholders == YES {
@@ -329,7 +749,11 @@ func inject(project_dir string, order *Order) string {
 		}
 	}
 
-	if order.Overwrite != UNSPECIFIED {
+	// a redo's frames are presumably broken, so
+	// they're overwritten whatever the order says
+	if order.Redo_Frames != "" {
+		buffer.WriteString("bpy.context.scene.render.use_overwrite = True\n")
+	} else if order.Overwrite != UNSPECIFIED {
 		buffer.WriteString("bpy.context.scene.render.use_overwrite = ")
 		if order.Overwrite == YES {
 			buffer.WriteString("True\n")
@@ -340,30 +764,3 @@ func inject(project_dir string, order *Order) string {
 
 	return buffer.String()
 }
-
-/*func get_image_types(ext string) (string, bool) {
-	switch strings.ToLower(ext) {
-	case ".bmp":            return "BMP",      true
-	case ".cin", ".cineon": return "CINEON",   true
-	case ".hdr":            return "HDR",      true
-	case ".iris":           return "IRIS",     true
-	case ".iriz":           return "IRIZ",     true
-	case ".jp2":            return "JP2",      true
-	case ".jpg", ".jpeg":   return "JPEG",     true
-	case ".exr":            return "OPEN_EXR", true
-	case ".png":            return "PNG",      true
-	case ".tga":            return "TGA",      true
-	case ".tif", ".tiff":   return "TIFF",     true
-	case ".webp":           return "WEBP",     true
-	}
-
-	// "AVIJPEG"
-	// "AVIRAW"
-	// "DDS"
-	// "DPX"
-	// "MPEG"
-	// "OPEN_EXR_MULTILAYER"
-	// "RAWTGA"
-
-	return "", false
-}*/
@@ -0,0 +1,183 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "math"
+import "time"
+
+type Retry_Policy struct {
+	Attempts        uint    `toml:"attempts"`
+	Delay           uint    `toml:"delay"`   // seconds
+	Backoff         float64 `toml:"backoff"` // multiplier per attempt
+	No_Render_Cache bool    `toml:"no_render_cache"`
+}
+
+// an order's own --retry and --retry-delay, where
+// attempts is left out if only the delay was given
+type Order_Retry struct {
+	Attempts *uint `toml:"attempts,omitempty"`
+	Delay    uint  `toml:"delay"`
+}
+
+// the [retry] table in config.toml, which can
+// contain per-error [retry.kind.NAME] tables
+type Retry_Config struct {
+	Retry_Policy
+	Kinds map[string]*Kind_Policy `toml:"kind"`
+}
+
+// a rule for one kind of error, where anything
+// left out falls through to the policy beneath
+type Kind_Policy struct {
+	Attempts        *uint    `toml:"attempts"`
+	Delay           *uint    `toml:"delay"`
+	Backoff         *float64 `toml:"backoff"`
+	No_Render_Cache *bool    `toml:"no_render_cache"`
+}
+
+// these errors come from the machine or the file
+// itself, so trying again won't change anything
+func default_kind_policy(kind Blender_Error) (*Kind_Policy, bool) {
+	switch kind {
+	case GPU_NOT_SUPPORTED, RENDERER_NOT_SUPPORTED, RENDERER_KERNEL_FAIL, PYTHON_FAIL:
+		never := uint(0)
+		return &Kind_Policy{Attempts: &never}, true
+	case NO_MEMORY:
+		once, no_cache := uint(1), true
+		return &Kind_Policy{
+			Attempts:        &once,
+			No_Render_Cache: &no_cache,
+		}, true
+	}
+	return nil, false
+}
+
+// starting from the project's default, the built-in
+// policy for the kind of error is applied, then the
+// config's policy for that kind and finally whatever
+// was given explicitly for the order itself, which
+// can't bring back retries that a kind rules out
+func retry_policy(config *Config, order *Order, kind Blender_Error) Retry_Policy {
+	policy := config.Retry.Retry_Policy
+	never  := false
+
+	if kind_policy, ok := default_kind_policy(kind); ok {
+		never = policy.apply(kind_policy, never)
+	}
+
+	if kind_policy, ok := config.Retry.Kinds[kind.Name()]; ok {
+		never = policy.apply(kind_policy, never)
+	}
+
+	if order.Retry != nil {
+		if order.Retry.Attempts != nil && !never {
+			policy.Attempts = *order.Retry.Attempts
+		}
+		if order.Retry.Delay > 0 {
+			policy.Delay = order.Retry.Delay
+		}
+	}
+
+	return policy
+}
+
+// copies whatever the kind sets, reporting whether
+// it has ruled out retrying that kind altogether
+func (policy *Retry_Policy) apply(kind_policy *Kind_Policy, never bool) bool {
+	if kind_policy.Attempts != nil {
+		policy.Attempts = *kind_policy.Attempts
+		never = policy.Attempts == 0
+	}
+	if kind_policy.Delay != nil {
+		policy.Delay = *kind_policy.Delay
+	}
+	if kind_policy.Backoff != nil {
+		policy.Backoff = *kind_policy.Backoff
+	}
+	if kind_policy.No_Render_Cache != nil {
+		policy.No_Render_Cache = *kind_policy.No_Render_Cache
+	}
+	return never
+}
+
+func (policy Retry_Policy) delay_for(retry uint) time.Duration {
+	delay := float64(policy.Delay)
+
+	if policy.Backoff > 0 && retry > 1 {
+		delay *= math.Pow(policy.Backoff, float64(retry - 1))
+	}
+
+	return time.Duration(delay * float64(time.Second))
+}
+
+// runs the order (or chunk) until it either succeeds or
+// its retry policy gives up.  every retry resumes from
+// the frame after the last one that was saved
+func run_with_retries(config *Config, order *Order, chunk *Chunk, estimate *Queue_Estimate) bool {
+	order.no_render_cache = false
+	order.no_retry        = false
+
+	retries := uint(0)
+
+	for {
+		if run_order(config, order, chunk, estimate) {
+			return true
+		}
+
+		lock, lock_file := active_lock(config, order, chunk)
+		if lock.is_lost() {
+			return false
+		}
+
+		print_failure(order)
+
+		if order.no_retry {
+			return false
+		}
+
+		policy := retry_policy(config, order, order.Error_Kind)
+		if retries >= policy.Attempts {
+			return false
+		}
+
+		retries++
+
+		delay := policy.delay_for(retries)
+		printf(apply_color("[$1%s$0] retrying in %s (%d/%d)\n"), order.Name, format_duration(delay), retries, policy.Attempts)
+
+		// keep the lock alive while we wait, so
+		// nobody mistakes the pause for a crash
+		if lock != nil {
+			stop_heartbeat := start_heartbeat(lock_file, lock, nil)
+			time.Sleep(delay)
+			stop_heartbeat()
+
+			if lock.is_lost() {
+				eprintf(apply_color("[$1%s$0] lock was taken over by another machine, stopping\n"), order.Name)
+				return false
+			}
+		} else {
+			time.Sleep(delay)
+		}
+
+		if policy.No_Render_Cache {
+			order.no_render_cache = true
+		}
+	}
+}
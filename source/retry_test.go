@@ -0,0 +1,83 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "testing"
+import "github.com/BurntSushi/toml"
+
+func retry_config(t *testing.T, blob string) *Config {
+	config := new(Config)
+	if _, err := toml.Decode(blob, config); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func order_retry(attempts uint) *Order {
+	return &Order{Retry: &Order_Retry{Attempts: &attempts}}
+}
+
+func TestRetryKindDelayOnly(t *testing.T) {
+	config := retry_config(t, `
+[retry]
+attempts = 3
+delay = 30
+
+[retry.kind.FILESYSTEM_ERROR]
+delay = 120
+
+[retry.kind.NO_MEMORY]
+delay = 5
+`)
+
+	policy := retry_policy(config, &Order{}, FILESYSTEM_ERROR)
+	if policy.Attempts != 3 || policy.Delay != 120 {
+		t.Errorf("a kind with only a delay gave %+v", policy)
+	}
+
+	// the built-in rule for the kind still holds
+	policy = retry_policy(config, &Order{}, NO_MEMORY)
+	if policy.Attempts != 1 || !policy.No_Render_Cache || policy.Delay != 5 {
+		t.Errorf("NO_MEMORY with only a delay gave %+v", policy)
+	}
+}
+
+func TestRetryOrderOverride(t *testing.T) {
+	config := retry_config(t, `
+[retry]
+attempts = 1
+
+[retry.kind.PYTHON_FAIL]
+attempts = 2
+`)
+
+	if policy := retry_policy(config, order_retry(4), UNKNOWN_ERROR); policy.Attempts != 4 {
+		t.Errorf("the order's own attempts were ignored: %+v", policy)
+	}
+
+	// kinds that are never retried stay that way
+	if policy := retry_policy(config, order_retry(4), GPU_NOT_SUPPORTED); policy.Attempts != 0 {
+		t.Errorf("GPU_NOT_SUPPORTED was retried: %+v", policy)
+	}
+
+	// unless the project has allowed it
+	if policy := retry_policy(config, order_retry(4), PYTHON_FAIL); policy.Attempts != 4 {
+		t.Errorf("PYTHON_FAIL ignored the order once allowed: %+v", policy)
+	}
+}
@@ -0,0 +1,194 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "fmt"
+import "bytes"
+import "bufio"
+import "os/exec"
+import "strings"
+
+type Blend_Info struct {
+	Active string
+	Scenes []*Scene_Info
+}
+
+type Scene_Info struct {
+	Name         string
+	Start_Frame  int
+	End_Frame    int
+	Resolution_X uint
+	Resolution_Y uint
+	Percentage   uint
+	Frame_Rate   float64
+	View_Layers  []string
+	Cameras      []string
+	Markers      []Marker
+}
+
+// names are tab-separated because scene, layer
+// and object names can all contain spaces
+const INFO_EXPRESSION = `import bpy
+print("sous_active\t" + bpy.context.scene.name)
+for s in bpy.data.scenes:
+    r = s.render
+    print("sous_scene\t%s\t%d\t%d\t%d\t%d\t%d\t%f" % (s.name, s.frame_start, s.frame_end, r.resolution_x, r.resolution_y, r.resolution_percentage, r.fps / r.fps_base))
+    for l in s.view_layers:
+        print("sous_layer\t%s\t%s" % (s.name, l.name))
+    for o in s.objects:
+        if o.type == 'CAMERA':
+            print("sous_camera\t%s\t%s" % (s.name, o.name))
+    for m in s.timeline_markers:
+        print("sous_marker\t%s\t%s\t%d" % (s.name, m.name, m.frame))`
+
+// there should be better way to do this, but
+// reading Blender files reliably sucks
+func blend_info(config *Config, order *Order) (*Blend_Info, bool) {
+	blender_path, ok := get_blender_path(config, order.Blender_Target)
+	if !ok {
+		return nil, false
+	}
+
+	cmd := exec.Command(blender_path, "-b", order.Source_Path, "--python-expr", INFO_EXPRESSION)
+
+	var (
+		stdout []byte
+		err    error
+	)
+
+	config.outside_lock(func() {
+		stdout, err = cmd.Output()
+	})
+
+	// Blender's exit code doesn't matter as long
+	// as it printed what we were after
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		panic(err)
+	}
+
+	info := new(Blend_Info)
+
+	scanner := bufio.NewScanner(bytes.NewReader(stdout))
+
+	for scanner.Scan() {
+		part := strings.Split(scanner.Text(), "\t")
+
+		switch part[0] {
+		case "sous_active":
+			if len(part) == 2 {
+				info.Active = part[1]
+			}
+
+		case "sous_scene":
+			if len(part) < 7 {
+				continue
+			}
+
+			scene := &Scene_Info{Name: part[1]}
+
+			scene.Start_Frame,  _ = parse_int(part[2])
+			scene.End_Frame,    _ = parse_int(part[3])
+			scene.Resolution_X, _ = parse_uint(part[4])
+			scene.Resolution_Y, _ = parse_uint(part[5])
+
+			// the percentage is applied later, so
+			// that presets can override it
+			scene.Percentage, _ = parse_uint(part[6])
+
+			if len(part) > 7 {
+				scene.Frame_Rate, _ = parse_float(part[7])
+			}
+
+			info.Scenes = append(info.Scenes, scene)
+
+		case "sous_layer":
+			if scene, ok := info.find_scene(part[1]); ok && len(part) == 3 {
+				scene.View_Layers = append(scene.View_Layers, part[2])
+			}
+
+		case "sous_marker":
+			if scene, ok := info.find_scene(part[1]); ok && len(part) == 4 {
+				if frame, ok := parse_int(part[3]); ok {
+					scene.Markers = append(scene.Markers, Marker{part[2], frame})
+				}
+			}
+
+		case "sous_camera":
+			if scene, ok := info.find_scene(part[1]); ok && len(part) == 3 {
+				scene.Cameras = append(scene.Cameras, part[2])
+			}
+		}
+	}
+
+	return info, len(info.Scenes) > 0
+}
+
+func (info *Blend_Info) find_scene(name string) (*Scene_Info, bool) {
+	for _, scene := range info.Scenes {
+		if scene.Name == name {
+			return scene, true
+		}
+	}
+	return nil, false
+}
+
+func (info *Blend_Info) scene_names() string {
+	list := make([]string, 0, len(info.Scenes))
+	for _, scene := range info.Scenes {
+		list = append(list, scene.Name)
+	}
+	return strings.Join(list, ", ")
+}
+
+// checks the order's view layer and camera
+// actually exist in this scene
+func (scene *Scene_Info) validate(order *Order) bool {
+	if order.View_Layer != "" && !contains(scene.View_Layers, order.View_Layer) {
+		eprintf(apply_color("View layer $1%q$0 not found in scene %q, which has: %s\n"), order.View_Layer, scene.Name, strings.Join(scene.View_Layers, ", "))
+		return false
+	}
+
+	if order.Camera != "" && !contains(scene.Cameras, order.Camera) {
+		eprintf(apply_color("Camera $1%q$0 not found in scene %q, which has: %s\n"), order.Camera, scene.Name, strings.Join(scene.Cameras, ", "))
+		return false
+	}
+
+	return true
+}
+
+// what the scene lacks of the order's view layer
+// and camera, or nothing if it has both
+func (scene *Scene_Info) missing(order *Order) string {
+	if order.View_Layer != "" && !contains(scene.View_Layers, order.View_Layer) {
+		return fmt.Sprintf("view layer %q", order.View_Layer)
+	}
+	if order.Camera != "" && !contains(scene.Cameras, order.Camera) {
+		return fmt.Sprintf("camera %q", order.Camera)
+	}
+	return ""
+}
+
+func contains(list []string, value string) bool {
+	for _, x := range list {
+		if x == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,532 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "io"
+import "os"
+import "mime"
+import "sync"
+import "regexp"
+import "strings"
+import "net/http"
+import "path/filepath"
+import "encoding/json"
+
+const DEFAULT_SERVE_ADDR = "127.0.0.1:8421"
+
+// the server works on the queue directory exactly as the
+// CLI does, so everything is loaded fresh per request and
+// it can share the queue with any number of CLI users and
+// render processes.  requests are handled one at a time
+// because errors are collected through error_output,
+// except while Blender or BAT are working for one
+type Server struct {
+	config *Config
+	args   *Arguments
+	mutex  sync.Mutex
+}
+
+type Export_Single struct {
+	Schema_Version int           `json:"schema_version"`
+	Order          *Export_Order `json:"order"`
+}
+
+type Export_Failure struct {
+	Schema_Version int    `json:"schema_version"`
+	Error          string `json:"error"`
+}
+
+// the same options as the order command, by name
+type Create_Request struct {
+	Source_Path        string   `json:"source_path"`
+	Output_Path        string   `json:"output_path"`
+	Target             string   `json:"target"`
+	Preset             string   `json:"preset"`
+	Replace            string   `json:"replace"`
+	Cache              bool     `json:"cache"`
+	Scene              string   `json:"scene"`
+	View_Layer         string   `json:"view_layer"`
+	Camera             string   `json:"camera"`
+	All_Scenes         bool     `json:"all_scenes"`
+	Frames             string   `json:"frames"`
+	Chunk              uint     `json:"chunk"`
+	Priority           int      `json:"priority"`
+	After              []string `json:"after"`
+	Resolution         string   `json:"resolution"`
+	Percentage         uint     `json:"percentage"`
+	Format             string   `json:"format"`
+	Encode             string   `json:"encode"`
+	Engine             string   `json:"engine"`
+	Samples            uint     `json:"samples"`
+	Adaptive_Threshold float64  `json:"adaptive_threshold"`
+	Denoise            string   `json:"denoise"`
+	Light_Bounces      *uint    `json:"light_bounces"`
+	Simplify           *uint    `json:"simplify"`
+	Motion_Blur        string   `json:"motion_blur"`
+	Placeholders       string   `json:"placeholders"`
+	Overwrite          string   `json:"overwrite"`
+	Retry              *uint    `json:"retry"`
+	Retry_Delay        uint     `json:"retry_delay"`
+}
+
+type Move_Request struct {
+	To     string `json:"to"`
+	Target string `json:"target"`
+}
+
+type Redo_Request struct {
+	Frames string `json:"frames"`
+}
+
+func command_serve(config *Config, args *Arguments) {
+	addr := args.serve_addr
+	if addr == "" {
+		addr = DEFAULT_SERVE_ADDR
+	}
+
+	server := &Server{
+		config: config,
+		args:   args,
+	}
+
+	config.unlocked = server.unlocked
+
+	printf("Serving %s on http://%s\n", config.project_dir, addr)
+
+	if err := http.ListenAndServe(addr, server); err != nil {
+		eprintln(err.Error())
+	}
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	part := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	if part[0] != "api" {
+		serve_dashboard(w, r)
+		return
+	}
+
+	part = part[1:]
+
+	if len(part) == 0 || part[0] != "orders" {
+		write_failure(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	// browsers can't send JSON to another origin without
+	// asking first, so this keeps other sites from making
+	// changes to the queue behind the user's back
+	if (r.Method == http.MethodPost || r.Method == http.MethodDelete) && !is_json(r) {
+		write_failure(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+		return
+	}
+
+	switch len(part) {
+	case 1:
+		switch r.Method {
+		case http.MethodGet:
+			server.list_orders(w, r)
+		case http.MethodPost:
+			server.create_order(w, r)
+		default:
+			write_failure(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+
+	case 2:
+		switch r.Method {
+		case http.MethodGet:
+			server.get_order(w, r, part[1])
+		case http.MethodDelete:
+			server.delete_order(w, r, part[1])
+		default:
+			write_failure(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+
+	case 3:
+		if part[2] == "log" {
+			if r.Method != http.MethodGet {
+				write_failure(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			server.order_log(w, r, part[1])
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			write_failure(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		switch part[2] {
+		case "redo":
+			server.redo_order(w, r, part[1])
+		case "hold":
+			server.hold_order(w, r, part[1], true)
+		case "release":
+			server.hold_order(w, r, part[1], false)
+		case "move":
+			server.move_order(w, r, part[1])
+		default:
+			write_failure(w, http.StatusNotFound, "not found")
+		}
+
+	default:
+		write_failure(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (server *Server) list_orders(w http.ResponseWriter, r *http.Request) {
+	queue, errors, ok := server.load_queue()
+	if !ok {
+		write_failure(w, http.StatusInternalServerError, errors)
+		return
+	}
+	write_json(w, http.StatusOK, export_queue(server.config, queue))
+}
+
+func (server *Server) get_order(w http.ResponseWriter, r *http.Request, name string) {
+	queue, order, ok := server.find(w, name)
+	if !ok {
+		return
+	}
+	server.write_order(w, http.StatusOK, order, queue)
+}
+
+func (server *Server) create_order(w http.ResponseWriter, r *http.Request) {
+	request := Create_Request{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		write_failure(w, http.StatusBadRequest, "invalid request: " + err.Error())
+		return
+	}
+
+	if request.Source_Path == "" {
+		write_failure(w, http.StatusBadRequest, "source_path is required")
+		return
+	}
+
+	args, errors := server.order_arguments(&request)
+	if errors != "" {
+		write_failure(w, http.StatusBadRequest, errors)
+		return
+	}
+
+	var created []*Order
+
+	errors, ok := server.capture(func() bool {
+		list, ok := create_orders(server.config, args)
+		created = list
+		return ok
+	})
+
+	if !ok {
+		write_failure(w, http.StatusBadRequest, errors)
+		return
+	}
+
+	queue, _, _ := server.load_queue()
+
+	data := &Export_Queue{
+		Schema_Version: SCHEMA_VERSION,
+		Orders:         make([]*Export_Order, 0, len(created)),
+	}
+
+	for _, order := range created {
+		// reload so the response is exactly what's on disk
+		if saved, ok := find_order(queue, order.Name); ok {
+			order = saved
+		}
+		data.Orders = append(data.Orders, export_order(server.config, order, queue))
+	}
+
+	write_json(w, http.StatusCreated, data)
+}
+
+func (server *Server) delete_order(w http.ResponseWriter, r *http.Request, name string) {
+	_, order, ok := server.find(w, name)
+	if !ok {
+		return
+	}
+
+	if order_in_use(server.config, order) {
+		write_failure(w, http.StatusConflict, "order " + name + " is being rendered")
+		return
+	}
+
+	if err := os.RemoveAll(order_path(server.config.project_dir, order.Name)); err != nil {
+		write_failure(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (server *Server) redo_order(w http.ResponseWriter, r *http.Request, name string) {
+	_, order, ok := server.find(w, name)
+	if !ok {
+		return
+	}
+
+	request := Redo_Request{}
+	if !decode_optional(w, r, &request) {
+		return
+	}
+
+	errors, ok := server.capture(func() bool {
+		return redo_order(server.config, order, request.Frames)
+	})
+
+	if !ok {
+		write_failure(w, http.StatusBadRequest, errors)
+		return
+	}
+
+	server.get_order(w, r, name)
+}
+
+func (server *Server) hold_order(w http.ResponseWriter, r *http.Request, name string, hold bool) {
+	_, order, ok := server.find(w, name)
+	if !ok {
+		return
+	}
+
+	order.Held = hold
+
+	errors, ok := server.capture(func() bool {
+		return update_order(server.config.project_dir, order.Name, func(current *Order) {
+			current.Held = hold
+		})
+	})
+
+	if !ok {
+		write_failure(w, http.StatusInternalServerError, errors)
+		return
+	}
+
+	server.get_order(w, r, name)
+}
+
+func (server *Server) move_order(w http.ResponseWriter, r *http.Request, name string) {
+	queue, _, ok := server.find(w, name)
+	if !ok {
+		return
+	}
+
+	request := Move_Request{}
+	if !decode_optional(w, r, &request) {
+		return
+	}
+
+	errors, ok := server.capture(func() bool {
+		_, ok := move_order(server.config, queue, name, request.To, request.Target)
+		return ok
+	})
+
+	if !ok {
+		write_failure(w, http.StatusBadRequest, errors)
+		return
+	}
+
+	server.get_order(w, r, name)
+}
+
+func (server *Server) load_queue() (Order_Array, string, bool) {
+	var queue Order_Array
+
+	errors, ok := server.capture(func() bool {
+		list, ok := load_orders(server.config.project_dir, false)
+		queue = list
+		return ok
+	})
+
+	return queue, errors, ok
+}
+
+// loads the queue and finds the named order in it,
+// responding with the appropriate error if it can't
+func (server *Server) find(w http.ResponseWriter, name string) (Order_Array, *Order, bool) {
+	queue, errors, ok := server.load_queue()
+	if !ok {
+		write_failure(w, http.StatusInternalServerError, errors)
+		return nil, nil, false
+	}
+
+	order, ok := find_order(queue, name)
+	if !ok {
+		write_failure(w, http.StatusNotFound, "order " + name + " does not exist")
+		return nil, nil, false
+	}
+
+	return queue, order, true
+}
+
+func (server *Server) write_order(w http.ResponseWriter, status int, order *Order, queue Order_Array) {
+	write_json(w, status, &Export_Single{
+		Schema_Version: SCHEMA_VERSION,
+		Order:          export_order(server.config, order, queue),
+	})
+}
+
+var ansi_codes = regexp.MustCompile("\033\\[[0-9;]*[A-Za-z]")
+
+// lets other requests through while fn waits on a
+// subprocess, and puts this request's error output
+// back once it has the lock again
+func (server *Server) unlocked(fn func()) {
+	output := error_output
+	error_output = os.Stderr
+
+	server.mutex.Unlock()
+
+	defer func() {
+		server.mutex.Lock()
+		error_output = output
+	}()
+
+	fn()
+}
+
+// runs fn with everything it would have printed as an
+// error collected instead, ready to send to the client
+func (server *Server) capture(fn func() bool) (string, bool) {
+	buffer := strings.Builder{}
+
+	ok := collect_errors(&buffer, fn)
+
+	text := strings.TrimSpace(ansi_codes.ReplaceAllString(buffer.String(), ""))
+
+	if text != "" {
+		eprintln(text)
+	}
+
+	if !ok && text == "" {
+		text = "request failed"
+	}
+
+	return text, ok
+}
+
+// errors go to w for the length of fn, and back to
+// wherever they were going afterwards, even on panic
+func collect_errors(w io.Writer, fn func() bool) bool {
+	previous := error_output
+	error_output = w
+
+	defer func() {
+		error_output = previous
+	}()
+
+	return fn()
+}
+
+// turns a request into the same arguments the CLI would
+// produce, with relative paths taken from the project
+func (server *Server) order_arguments(request *Create_Request) (*Arguments, string) {
+	args := &Arguments{
+		command:            COMMAND_ORDER,
+		source_path:        server.project_path(request.Source_Path),
+		output_path:        server.project_path(request.Output_Path),
+		blender_target:     request.Target,
+		preset:             request.Preset,
+		replace_id:         request.Replace,
+		bank_order:         request.Cache,
+		scene:              request.Scene,
+		view_layer:         request.View_Layer,
+		camera:             request.Camera,
+		all_scenes:         request.All_Scenes,
+		frames:             request.Frames,
+		chunk_size:         request.Chunk,
+		priority:           request.Priority,
+		after:              request.After,
+		percentage:         request.Percentage,
+		file_format:        request.Format,
+		encode:             request.Encode,
+		engine:             request.Engine,
+		samples:            request.Samples,
+		adaptive_threshold: request.Adaptive_Threshold,
+		denoise:            parse_fallback_bool(request.Denoise),
+		light_bounces:      request.Light_Bounces,
+		simplify:           request.Simplify,
+		motion_blur:        parse_fallback_bool(request.Motion_Blur),
+		use_placeholders:   parse_fallback_bool(request.Placeholders),
+		overwrite:          parse_fallback_bool(request.Overwrite),
+		retry_delay:        request.Retry_Delay,
+		is_bat_installed:   server.args.is_bat_installed,
+	}
+
+	if request.Retry != nil {
+		args.retry_set      = true
+		args.retry_attempts = *request.Retry
+	}
+
+	if request.Resolution != "" {
+		x, y, ok := parse_resolution(request.Resolution)
+		if !ok {
+			return nil, "invalid resolution " + request.Resolution
+		}
+		args.resolution_x = x
+		args.resolution_y = y
+	}
+
+	return args, ""
+}
+
+func (server *Server) project_path(path string) string {
+	if path == "" {
+		return server.config.project_dir
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(server.config.project_dir, filepath.FromSlash(path))
+}
+
+func is_json(r *http.Request) bool {
+	media, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && media == "application/json"
+}
+
+// action bodies are optional, so an empty one is fine
+func decode_optional(w http.ResponseWriter, r *http.Request, data any) bool {
+	if r.ContentLength == 0 {
+		return true
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(data); err != nil {
+		write_failure(w, http.StatusBadRequest, "invalid request: " + err.Error())
+		return false
+	}
+	return true
+}
+
+func write_json(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	encoder.Encode(data)
+}
+
+func write_failure(w http.ResponseWriter, status int, message string) {
+	write_json(w, status, &Export_Failure{SCHEMA_VERSION, message})
+}
@@ -32,6 +32,10 @@ const ORDER_DIR     = SOUS_DIR + "/orders"
 const CONFIG_PATH   = SOUS_DIR + "/config.toml"
 const MANIFEST_NAME = "order.toml"
 const LOCK_NAME     = "lock.txt"
+const CHUNK_DIR     = "chunks"
+const COMPLETE_LOCK = "complete.lock"
+const LOG_DIR       = "logs"
+const STATS_DIR     = "stats"
 
 const (
 	COMMAND_ORDER uint8 = iota
@@ -44,24 +48,72 @@ const (
 	COMMAND_REDO
 	COMMAND_DELETE
 	COMMAND_TARGET
+	COMMAND_LOCKS
+	COMMAND_LOG
+	COMMAND_MOVE
+	COMMAND_HOLD
+	COMMAND_RELEASE
+	COMMAND_VERIFY
+	COMMAND_STATUS
+	COMMAND_SERVE
+	COMMAND_NOTIFY
+	COMMAND_ENCODE
+	COMMAND_CONTACT
+	COMMAND_STATS
 )
 
 type Arguments struct {
-	command    uint8
-	hard_clean bool
+	command      uint8
+	hard_clean   bool
+	retry_failed bool
+	verify_redo  bool
+	watch        bool
+	output       uint8
+	serve_addr   string
+	notify_event string
+	notifier     string
+	break_lock   string
+	follow_log   bool
+	log_run      uint
+	move_to      string
+	move_target  string
+	names        []string
 
 	replace_id string
 
 	bank_order       bool
-	start_frame      uint
-	end_frame        uint
+	preset           string
+	encode           string
+	contact_every    uint
+	contact_columns  uint
+	frames           string
+	frame_step       uint
+	chunk_size       uint
+	retry_set        bool
+	retry_attempts   uint
+	retry_delay      uint
+	priority         int
+	after            []string
 	resolution_x     uint
 	resolution_y     uint
+	percentage       uint
+	engine             string
+	samples            uint
+	adaptive_threshold float64
+	denoise            uint8
+	light_bounces      *uint
+	simplify           *uint
+	motion_blur        uint8
+	file_format      string
 	overwrite        uint8
 	use_placeholders uint8
 	source_path      string
 	output_path      string
 	blender_target   string
+	scene            string
+	view_layer       string
+	camera           string
+	all_scenes       bool
 
 	is_bat_installed bool
 }
@@ -70,8 +122,22 @@ type Config struct {
 	project_dir  string
 	own_hostname string
 
+	// set by the server, so that other requests
+	// aren't held up by a slow subprocess
+	unlocked func(fn func())
+
 	Default_Target string             `toml:"default_target"`
+	Default_Chunk  uint               `toml:"default_chunk"`
+	Lock_Timeout   uint               `toml:"lock_timeout"`
+	Log_Limit      uint               `toml:"log_limit"`
 	Blender_Target []*Blender_Version `toml:"target"`
+	Presets        []*Preset          `toml:"preset"`
+	Retry          Retry_Config       `toml:"retry"`
+	Hooks          Hook_Config        `toml:"hooks"`
+	Notify         []*Notifier        `toml:"notify"`
+	FFmpeg         string             `toml:"ffmpeg"`
+	Encode         []*Encode_Spec     `toml:"encode"`
+	Contact        Contact_Config     `toml:"contact"`
 }
 
 type Blender_Version struct {
@@ -105,9 +171,19 @@ func main() {
 		return
 	}
 
+	// a broken notifier only stops the commands that send
+	// notifications, so the queue can still be looked at
+	// and sorted out while config.toml is being fixed
+	switch args.command {
+	case COMMAND_RENDER, COMMAND_ENCODE, COMMAND_NOTIFY:
+		if !check_notifiers(config) {
+			return
+		}
+	}
+
 	switch args.command {
 	case COMMAND_LIST:
-		command_list(config)
+		command_list(config, args)
 
 	case COMMAND_CLEAN:
 		command_clean(config, args)
@@ -126,6 +202,42 @@ func main() {
 
 	case COMMAND_TARGET:
 		command_targets(config, args)
+
+	case COMMAND_LOCKS:
+		command_locks(config, args)
+
+	case COMMAND_LOG:
+		command_log(config, args)
+
+	case COMMAND_MOVE:
+		command_move(config, args)
+
+	case COMMAND_HOLD:
+		command_hold(config, args, true)
+
+	case COMMAND_RELEASE:
+		command_hold(config, args, false)
+
+	case COMMAND_VERIFY:
+		command_verify(config, args)
+
+	case COMMAND_STATUS:
+		command_status(config, args)
+
+	case COMMAND_SERVE:
+		command_serve(config, args)
+
+	case COMMAND_NOTIFY:
+		command_notify(config, args)
+
+	case COMMAND_ENCODE:
+		command_encode(config, args)
+
+	case COMMAND_CONTACT:
+		command_contact(config, args)
+
+	case COMMAND_STATS:
+		command_stats(config, args)
 	}
 }
 
@@ -230,6 +342,28 @@ func preset_res_table(arg string) (uint, uint) {
 	return 0, 0
 }
 
+// accepts either "1920x1080" or one of the
+// shortcuts in preset_res_table
+func parse_resolution(arg string) (uint, uint, bool) {
+	part := strings.SplitN(arg, "x", 2)
+
+	if len(part) == 1 {
+		x, y := preset_res_table(part[0])
+		return x, y, x > 0
+	}
+
+	x, ok := parse_uint(part[0])
+	if !ok {
+		return 0, 0, false
+	}
+	y, ok := parse_uint(part[1])
+	if !ok {
+		return 0, 0, false
+	}
+
+	return x, y, true
+}
+
 // extracts arguments in the array as
 // either --bool or --name <data>
 func pull_argument(args []string) (string, string) {
@@ -258,7 +392,7 @@ func pull_argument(args []string) (string, string) {
 		if len(args[1:]) >= 1 {
 			b := args[1]
 
-			if len(b) > 0 && b[0] != '-' {
+			if len(b) > 0 && (b[0] != '-' || is_negative_number(b)) {
 				return a, b
 			}
 		}
@@ -269,6 +403,12 @@ func pull_argument(args []string) (string, string) {
 	return "", ""
 }
 
+// lets values like "--priority -5" through
+// without mistaking them for another flag
+func is_negative_number(arg string) bool {
+	return len(arg) > 1 && arg[0] == '-' && arg[1] >= '0' && arg[1] <= '9'
+}
+
 func get_arguments() (*Arguments, bool) {
 	args := os.Args[1:]
 	conf := new(Arguments)
@@ -277,6 +417,10 @@ func get_arguments() (*Arguments, bool) {
 	patharg    := 0
 	has_errors := false
 
+	// the command can only be the first argument, so
+	// orders named after one can still be addressed
+	is_first := true
+
 	for {
 		args = args[counter:]
 
@@ -286,7 +430,9 @@ func get_arguments() (*Arguments, bool) {
 
 		counter = 0
 
-		if len(args) > 0 {
+		if is_first {
+			is_first = false
+
 			switch args[0] {
 			case "init":
 				conf.command = COMMAND_INIT
@@ -328,6 +474,66 @@ func get_arguments() (*Arguments, bool) {
 				args = args[1:]
 				continue
 
+			case "locks":
+				conf.command = COMMAND_LOCKS
+				args = args[1:]
+				continue
+
+			case "log":
+				conf.command = COMMAND_LOG
+				args = args[1:]
+				continue
+
+			case "move":
+				conf.command = COMMAND_MOVE
+				args = args[1:]
+				continue
+
+			case "hold":
+				conf.command = COMMAND_HOLD
+				args = args[1:]
+				continue
+
+			case "release":
+				conf.command = COMMAND_RELEASE
+				args = args[1:]
+				continue
+
+			case "serve":
+				conf.command = COMMAND_SERVE
+				args = args[1:]
+				continue
+
+			case "status":
+				conf.command = COMMAND_STATUS
+				args = args[1:]
+				continue
+
+			case "verify":
+				conf.command = COMMAND_VERIFY
+				args = args[1:]
+				continue
+
+			case "notify":
+				conf.command = COMMAND_NOTIFY
+				args = args[1:]
+				continue
+
+			case "encode":
+				conf.command = COMMAND_ENCODE
+				args = args[1:]
+				continue
+
+			case "contact":
+				conf.command = COMMAND_CONTACT
+				args = args[1:]
+				continue
+
+			case "stats":
+				conf.command = COMMAND_STATS
+				args = args[1:]
+				continue
+
 			case "help":
 				conf.command = COMMAND_HELP
 				return conf, true // exit immediately
@@ -356,72 +562,249 @@ func get_arguments() (*Arguments, bool) {
 			conf.hard_clean = true
 			continue
 
+		case "redo":
+			conf.verify_redo = true
+			continue
+
+		case "addr":
+			counter++
+			conf.serve_addr = b
+			continue
+
+		case "event":
+			counter++
+			conf.notify_event = b
+			continue
+
+		case "json":
+			conf.output = OUTPUT_JSON
+			continue
+
+		case "toml":
+			conf.output = OUTPUT_TOML
+			continue
+
+		case "watch", "w":
+			conf.watch = true
+			continue
+
+		case "retry-failed":
+			conf.retry_failed = true
+			continue
+
+		case "follow":
+			conf.follow_log = true
+			continue
+
+		case "run":
+			counter++
+			if x, ok := parse_uint(b); ok {
+				conf.log_run = x
+			}
+			continue
+
+		case "break":
+			counter++
+			conf.break_lock = b
+			continue
+
 		case "replace":
 			counter++
 			conf.replace_id = b
 			continue
 
-		case "overwrite", "o":
+		case "retry":
 			counter++
-			b = strings.ToLower(b)
-			if b == "yes" {
-				conf.overwrite = YES
-			} else if b == "no" {
-				conf.overwrite = NO
+			if x, ok := parse_uint(b); ok {
+				conf.retry_set      = true
+				conf.retry_attempts = x
 			}
 			continue
 
-		case "placeholders", "p":
+		case "retry-delay":
 			counter++
-			b = strings.ToLower(b)
-			if b == "yes" {
-				conf.use_placeholders = YES
-			} else if b == "no" {
-				conf.use_placeholders = NO
+			if x, ok := parse_uint(b); ok {
+				conf.retry_delay = x
 			}
 			continue
 
-		case "resolution", "r":
+		// a position for move, but dependencies for order
+		case "after":
 			counter++
-			part := strings.SplitN(b, "x", 2)
 
-			switch len(part) {
-			case 1:
-				conf.resolution_x, conf.resolution_y = preset_res_table(part[0])
+			if conf.command == COMMAND_MOVE {
+				conf.move_to     = a
+				conf.move_target = b
+				continue
+			}
 
-				if conf.resolution_x == 0 {
-					eprintf("unknown preset %q\n", part[0])
-				}
-			case 2:
-				if x, ok := parse_uint(part[0]); ok {
-					conf.resolution_x = x
-				}
-				if y, ok := parse_uint(part[1]); ok {
-					conf.resolution_y = y
+			for _, name := range strings.Split(b, ",") {
+				name = strings.TrimSpace(name)
+				if name != "" {
+					conf.after = append(conf.after, name)
 				}
 			}
 			continue
 
-		case "frame", "f":
+		case "scene":
+			counter++
+			conf.scene = b
+			continue
+
+		case "view-layer":
 			counter++
-			part := strings.SplitN(b, ":", 2)
+			conf.view_layer = b
+			continue
 
-			switch len(part) {
-			case 1:
-				if x, ok := parse_uint(part[0]); ok {
-					conf.end_frame = x
-				}
-				conf.start_frame = 1
-			case 2:
-				if x, ok := parse_uint(part[0]); ok {
-					conf.start_frame = x
-				}
-				if x, ok := parse_uint(part[1]); ok {
-					conf.end_frame = x
-				}
+		case "camera":
+			counter++
+			conf.camera = b
+			continue
+
+		case "all-scenes":
+			conf.all_scenes = true
+			continue
+
+		case "engine":
+			counter++
+			conf.engine = b
+			continue
+
+		case "samples":
+			counter++
+			if x, ok := parse_uint(b); ok {
+				conf.samples = x
+			} else {
+				eprintf("Arguments: %q is not a valid sample count\n", b)
+				has_errors = true
+			}
+			continue
+
+		case "adaptive":
+			counter++
+			if x, ok := parse_float(b); ok {
+				conf.adaptive_threshold = x
+			} else {
+				eprintf("Arguments: %q is not a valid adaptive threshold\n", b)
+				has_errors = true
+			}
+			continue
+
+		case "denoise":
+			counter++
+			conf.denoise = parse_fallback_bool(b)
+			continue
+
+		case "bounces":
+			counter++
+			if x, ok := parse_uint(b); ok {
+				conf.light_bounces = &x
+			} else {
+				eprintf("Arguments: %q is not a valid bounce count\n", b)
+				has_errors = true
+			}
+			continue
+
+		case "simplify":
+			counter++
+			if x, ok := parse_uint(b); ok {
+				conf.simplify = &x
+			} else {
+				eprintf("Arguments: %q is not a valid simplify level\n", b)
+				has_errors = true
+			}
+			continue
+
+		case "motion-blur":
+			counter++
+			conf.motion_blur = parse_fallback_bool(b)
+			continue
+
+		case "format", "F":
+			counter++
+			conf.file_format = b
+			continue
+
+		case "priority":
+			counter++
+			if x, ok := parse_int(b); ok {
+				conf.priority = x
+			}
+			continue
+
+		case "top", "bottom":
+			conf.move_to = a
+			continue
+
+		case "before":
+			counter++
+			conf.move_to     = a
+			conf.move_target = b
+			continue
+
+		case "chunk":
+			counter++
+			if x, ok := parse_uint(b); ok {
+				conf.chunk_size = x
+			}
+			continue
+
+		case "preset":
+			counter++
+			conf.preset = b
+			continue
+
+		case "encode":
+			counter++
+			conf.encode = b
+			continue
+
+		case "every":
+			counter++
+			if x, ok := parse_uint(b); ok {
+				conf.contact_every = x
+			} else {
+				eprintf("Arguments: %q is not a valid frame step\n", b)
+				has_errors = true
 			}
 			continue
 
+		case "columns":
+			counter++
+			if x, ok := parse_uint(b); ok {
+				conf.contact_columns = x
+			} else {
+				eprintf("Arguments: %q is not a valid column count\n", b)
+				has_errors = true
+			}
+			continue
+
+		case "overwrite", "o":
+			counter++
+			conf.overwrite = parse_fallback_bool(b)
+			continue
+
+		case "placeholders", "p":
+			counter++
+			conf.use_placeholders = parse_fallback_bool(b)
+			continue
+
+		case "resolution", "r":
+			counter++
+			x, y, ok := parse_resolution(b)
+			if !ok {
+				eprintf("unknown resolution %q\n", b)
+				continue
+			}
+			conf.resolution_x = x
+			conf.resolution_y = y
+			continue
+
+		case "frame", "frames", "f":
+			counter++
+			conf.frames = b
+			continue
+
 		case "version":
 			conf.command = COMMAND_VERSION
 			return conf, true
@@ -442,19 +825,29 @@ func get_arguments() (*Arguments, bool) {
 			}
 		}
 
+		conf.names = append(conf.names, args[0])
+
 		switch patharg {
 		case 0:
+			// notify's only argument is a notifier's name
+			if conf.command == COMMAND_NOTIFY {
+				conf.notifier = args[0]
+				break
+			}
 			conf.source_path = args[0]
 		case 1:
 			conf.output_path = args[0]
-		default:
-			eprintf("Arguments: too many path arguments\n")
-			has_errors = true
 		}
 
 		patharg++
 	}
 
+	// only a few commands accept any number of names
+	if patharg > 2 && conf.command != COMMAND_HOLD && conf.command != COMMAND_RELEASE {
+		eprintf("Arguments: too many path arguments\n")
+		has_errors = true
+	}
+
 	if conf.command == COMMAND_ORDER && conf.source_path == "" {
 		conf.command = COMMAND_HELP
 		has_errors = true
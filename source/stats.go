@@ -0,0 +1,725 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "os"
+import "fmt"
+import "sort"
+import "time"
+import "strings"
+import "strconv"
+import "path/filepath"
+import "encoding/json"
+
+const SLOWEST_FRAMES = 5
+
+const STATS_EXT = ".jsonl"
+
+// one rendered frame.  every process appends these to
+// its own file in the order's stats directory, named for
+// its host and PID, so that chunks finishing at the same
+// time never write to the same file, even on one machine
+type Frame_Stat struct {
+	Frame        int       `json:"frame"`
+	Host         string    `json:"host"`
+	Time         time.Time `json:"time"`
+	Seconds      float64   `json:"seconds"`
+	Peak_Memory  float64   `json:"peak_memory"` // megabytes
+	Samples      uint      `json:"samples"`
+	Sample_Total uint      `json:"sample_total"`
+}
+
+// one record per line, so a broken one
+// only loses that frame, not the file
+func append_stat(config *Config, order *Order, stat *Frame_Stat) bool {
+	dir := stats_dir(config.project_dir, order.Name)
+
+	if !make_directory(dir) {
+		return false
+	}
+
+	blob, err := json.Marshal(stat)
+	if err != nil {
+		return false
+	}
+
+	name := fmt.Sprintf("%s-%d%s", config.own_hostname, os.Getpid(), STATS_EXT)
+
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_APPEND | os.O_CREATE | os.O_WRONLY, 0644)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	// in one write, so a reader never sees half a record
+	_, err = file.Write(append(blob, '\n'))
+	return err == nil
+}
+
+// every record from every machine, oldest first
+func load_stats(project_dir, name string) []*Frame_Stat {
+	dir := stats_dir(project_dir, name)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	list := make([]*Frame_Stat, 0, 64)
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != STATS_EXT {
+			continue
+		}
+
+		blob, ok := load_file(filepath.Join(dir, entry.Name()))
+		if !ok {
+			continue
+		}
+
+		// stats are only ever used for estimates, so a
+		// record that can't be read, most likely from a
+		// process killed mid-write, is simply left out
+		for _, line := range strings.Split(blob, "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+
+			stat := &Frame_Stat{}
+			if err := json.Unmarshal([]byte(line), stat); err != nil {
+				continue
+			}
+
+			list = append(list, stat)
+		}
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Time.Before(list[j].Time)
+	})
+
+	return list
+}
+
+// frames that were rendered more than once, such as
+// after a redo, only count their most recent render
+func latest_stats(list []*Frame_Stat) []*Frame_Stat {
+	seen := make(map[int]*Frame_Stat, len(list))
+
+	for _, stat := range list {
+		seen[stat.Frame] = stat
+	}
+
+	latest := make([]*Frame_Stat, 0, len(seen))
+	for _, stat := range seen {
+		latest = append(latest, stat)
+	}
+
+	sort.Slice(latest, func(i, j int) bool {
+		return latest[i].Frame < latest[j].Frame
+	})
+
+	return latest
+}
+
+// the median frame time, which is far less thrown off by
+// a slow first frame or one heavy shot than the mean is
+func typical_frame_time(list []*Frame_Stat) float64 {
+	latest := latest_stats(list)
+
+	times := make([]float64, len(latest))
+	for i, stat := range latest {
+		times[i] = stat.Seconds
+	}
+
+	sort.Float64s(times)
+	return median(times)
+}
+
+// expects the values to be sorted already
+func median(values []float64) float64 {
+	n := len(values)
+	if n == 0 {
+		return 0
+	}
+	if n % 2 == 0 {
+		return (values[n / 2 - 1] + values[n / 2]) / 2
+	}
+	return values[n / 2]
+}
+
+func format_seconds(seconds float64) string {
+	if seconds < 60 {
+		return fmt.Sprintf("%.1fs", seconds)
+	}
+	return format_duration(time.Duration(seconds * float64(time.Second)))
+}
+
+func format_memory(megabytes float64) string {
+	if megabytes >= 1024 {
+		return fmt.Sprintf("%.2fG", megabytes / 1024)
+	}
+	return fmt.Sprintf("%.0fM", megabytes)
+}
+
+/*
+	parsing
+*/
+
+// the largest of the "Peak" figures on a status line,
+// which come as both "(Peak 279.48M)" for the whole
+// process and "Peak:22.29M" for the render device
+func parse_peak_memory(input string) float64 {
+	peak := 0.0
+
+	for {
+		index := strings.Index(input, "Peak")
+		if index < 0 {
+			break
+		}
+
+		input = strings.TrimLeft(input[index + 4:], ": ")
+
+		end := 0
+		for end < len(input) && (input[end] == '.' || (input[end] >= '0' && input[end] <= '9')) {
+			end++
+		}
+
+		value, err := strconv.ParseFloat(input[:end], 64)
+		if err != nil || end >= len(input) {
+			continue
+		}
+
+		switch input[end] {
+		case 'K':
+			value /= 1024
+		case 'G':
+			value *= 1024
+		case 'M':
+		default:
+			continue
+		}
+
+		if value > peak {
+			peak = value
+		}
+	}
+
+	return peak
+}
+
+// Cycles reports "Sample 32/128" and EEVEE
+// reports "Rendering 32 / 64 samples"
+func parse_samples(input string) (uint, uint, bool) {
+	text := ""
+
+	if index := strings.Index(input, "| Sample "); index > -1 {
+		text = input[index + 9:]
+	} else if index := strings.Index(input, "| Rendering "); index > -1 && strings.Contains(input, " samples") {
+		text = input[index + 12:]
+	} else {
+		return 0, 0, false
+	}
+
+	if index := strings.IndexAny(text, "|,s"); index > -1 {
+		text = text[:index]
+	}
+
+	parts := strings.SplitN(text, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	done, ok_done   := parse_uint(strings.TrimSpace(parts[0]))
+	total, ok_total := parse_uint(strings.TrimSpace(parts[1]))
+
+	return done, total, ok_done && ok_total
+}
+
+// Blender's "00:12.34" or "01:00:12.34"
+func parse_blender_time(input string) (float64, bool) {
+	parts := strings.Split(input, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseFloat(parts[len(parts) - 1], 64)
+	if err != nil {
+		return 0, false
+	}
+
+	scale := 60.0
+	for i := len(parts) - 2; i >= 0; i-- {
+		value, ok := parse_uint(parts[i])
+		if !ok {
+			return 0, false
+		}
+		seconds += float64(value) * scale
+		scale   *= 60
+	}
+
+	return seconds, true
+}
+
+// Blender's last word on a frame, after "Saved:",
+// is " Time: 00:12.34 (Saving: 00:00.10)"
+func parse_final_time(input string) (float64, bool) {
+	input = strings.TrimSpace(input)
+
+	if !strings.HasPrefix(input, "Time:") || !strings.Contains(input, "Saving:") {
+		return 0, false
+	}
+
+	fields := strings.Fields(input[5:])
+	if len(fields) == 0 {
+		return 0, false
+	}
+
+	return parse_blender_time(fields[0])
+}
+
+/*
+	tracking during a render
+*/
+
+// follows Blender's output for a single run, recording
+// each frame as it's saved and keeping the estimates
+// shown alongside the progress up to date
+type Frame_Tracker struct {
+	config *Config
+	order  *Order
+
+	frame   int
+	active  bool
+	started time.Time
+	peak    float64
+	samples uint
+	total   uint
+
+	// saved, but waiting on Blender's final time
+	pending *Frame_Stat
+
+	times      []float64 // sorted, this order's frames
+	frame_time float64
+	fallback   float64 // the rest of the queue's, until we have our own
+
+	left        int     // frames left in the order
+	order_hosts int
+	queue_hosts int
+	queue_work  float64 // seconds of rendering left in other orders
+}
+
+// the estimate is for the queue as it was when this
+// order was picked, while the order's own progress is
+// read fresh, as it moves on with every chunk
+func start_tracking(config *Config, order *Order, estimate *Queue_Estimate) *Frame_Tracker {
+	tracker := &Frame_Tracker{
+		config:      config,
+		order:       order,
+		order_hosts: 1,
+		queue_hosts: 1,
+	}
+
+	for _, stat := range latest_stats(load_stats(config.project_dir, order.Name)) {
+		tracker.times = append(tracker.times, stat.Seconds)
+	}
+
+	sort.Float64s(tracker.times)
+	tracker.frame_time = median(tracker.times)
+
+	progress := order_progress(config, order)
+
+	tracker.left = progress.Total - progress.Done
+	if len(progress.Hosts) > 1 {
+		tracker.order_hosts = len(progress.Hosts)
+	}
+
+	if estimate == nil {
+		return tracker
+	}
+
+	tracker.queue_hosts = estimate.hosts
+	tracker.fallback    = estimate.frame_time
+
+	for name, other := range estimate.progress {
+		if name != order.Name {
+			tracker.queue_work += other.work()
+		}
+	}
+
+	return tracker
+}
+
+func (tracker *Frame_Tracker) line(input string) {
+	if frame, ok := parse_frame_line(input); ok {
+		if !tracker.active || frame != tracker.frame {
+			tracker.flush()
+
+			tracker.frame   = frame
+			tracker.active  = true
+			tracker.started = time.Now()
+			tracker.peak    = 0
+			tracker.samples = 0
+			tracker.total   = 0
+		}
+
+		if peak := parse_peak_memory(input); peak > tracker.peak {
+			tracker.peak = peak
+		}
+
+		if done, total, ok := parse_samples(input); ok {
+			tracker.samples = done
+			tracker.total   = total
+		}
+		return
+	}
+
+	if strings.HasPrefix(input, "Saved:") && tracker.active && tracker.pending == nil {
+		tracker.pending = &Frame_Stat{
+			Frame:        tracker.frame,
+			Host:         tracker.config.own_hostname,
+			Seconds:      time.Since(tracker.started).Seconds(),
+			Peak_Memory:  tracker.peak,
+			Samples:      tracker.samples,
+			Sample_Total: tracker.total,
+		}
+
+		// File Output nodes can save several times
+		// per frame, but the frame is only counted once
+		tracker.active = false
+		return
+	}
+
+	if seconds, ok := parse_final_time(input); ok && tracker.pending != nil {
+		tracker.pending.Seconds = seconds
+		tracker.flush()
+	}
+}
+
+func (tracker *Frame_Tracker) flush() {
+	stat := tracker.pending
+	if stat == nil {
+		return
+	}
+
+	tracker.pending = nil
+
+	stat.Time = time.Now()
+	append_stat(tracker.config, tracker.order, stat)
+
+	index := sort.SearchFloat64s(tracker.times, stat.Seconds)
+	tracker.times = append(tracker.times, 0)
+	copy(tracker.times[index + 1:], tracker.times[index:])
+	tracker.times[index] = stat.Seconds
+
+	tracker.frame_time = median(tracker.times)
+
+	if tracker.left > 0 {
+		tracker.left--
+	}
+}
+
+// a frame that was saved before Blender stopped
+// still counts, even without its final time
+func (tracker *Frame_Tracker) finish() {
+	tracker.flush()
+}
+
+func (tracker *Frame_Tracker) eta_message() string {
+	frame_time := tracker.frame_time
+	if frame_time <= 0 {
+		frame_time = tracker.fallback
+	}
+	if frame_time <= 0 {
+		return ""
+	}
+
+	work  := float64(tracker.left) * frame_time
+	order := work / float64(tracker.order_hosts)
+	queue := (work + tracker.queue_work) / float64(tracker.queue_hosts)
+
+	return fmt.Sprintf(" | eta %s, queue %s", format_seconds(order), format_seconds(queue))
+}
+
+/*
+	estimates
+*/
+
+type Queue_Estimate struct {
+	progress   map[string]*Order_Progress // only the orders still to render
+	frame_time float64                    // typical across orders, for orders without stats
+	hosts      int                        // machines rendering right now, at least one
+}
+
+// how long everything that can still render will take,
+// from each order's own frame times, or from the rest
+// of the queue's for orders that haven't started.  held,
+// failed and blocked orders aren't counted
+func estimate_queue(config *Config, queue Order_Array) *Queue_Estimate {
+	estimate := &Queue_Estimate{
+		progress: make(map[string]*Order_Progress, len(queue)),
+		hosts:    1,
+	}
+
+	hosts := make(map[string]bool, 8)
+	times := make([]float64, 0, len(queue))
+
+	for _, order := range queue {
+		progress := order_progress(config, order)
+
+		frame_time := progress.frame_time
+		if order.Status == STATUS_COMPLETE {
+			frame_time = typical_frame_time(load_stats(config.project_dir, order.Name))
+		}
+		if frame_time > 0 {
+			times = append(times, frame_time)
+		}
+
+		for _, host := range progress.Hosts {
+			hosts[host] = true
+		}
+
+		switch order_state(config, order, queue) {
+		case "pending", "waiting", "rendering", "interrupted":
+			estimate.progress[order.Name] = progress
+		}
+	}
+
+	sort.Float64s(times)
+	estimate.frame_time = median(times)
+
+	if len(hosts) > 1 {
+		estimate.hosts = len(hosts)
+	}
+
+	for _, progress := range estimate.progress {
+		if progress.frame_time <= 0 {
+			progress.frame_time = estimate.frame_time
+		}
+	}
+
+	return estimate
+}
+
+// shared between however many machines are rendering
+func (estimate *Queue_Estimate) eta() (time.Duration, bool) {
+	work := 0.0
+	for _, progress := range estimate.progress {
+		work += progress.work()
+	}
+
+	if work <= 0 {
+		return 0, false
+	}
+
+	return time.Duration(work / float64(estimate.hosts) * float64(time.Second)), true
+}
+
+/*
+	command
+*/
+
+type Host_Stats struct {
+	host    string
+	seconds float64
+	frames  int
+}
+
+// total render time per machine, counting
+// every render of a frame, not just the latest
+func host_stats(list []*Frame_Stat) []*Host_Stats {
+	hosts := make(map[string]*Host_Stats, 4)
+
+	for _, stat := range list {
+		entry, ok := hosts[stat.Host]
+		if !ok {
+			entry = &Host_Stats{host: stat.Host}
+			hosts[stat.Host] = entry
+		}
+		entry.seconds += stat.Seconds
+		entry.frames  += 1
+	}
+
+	sorted := make([]*Host_Stats, 0, len(hosts))
+	for _, entry := range hosts {
+		sorted = append(sorted, entry)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].seconds > sorted[j].seconds
+	})
+
+	return sorted
+}
+
+// the latest render of each frame, slowest first
+func slowest_stats(list []*Frame_Stat) []*Frame_Stat {
+	latest := latest_stats(list)
+
+	sort.SliceStable(latest, func(i, j int) bool {
+		return latest[i].Seconds > latest[j].Seconds
+	})
+
+	return latest
+}
+
+func command_stats(config *Config, args *Arguments) {
+	queue, ok := load_orders(config.project_dir, false)
+	if !ok {
+		return
+	}
+
+	if args.source_path != "" {
+		order, ok := find_order(queue, args.source_path)
+		if !ok {
+			eprintf(apply_color("Order $1%q$0 does not exist\n"), args.source_path)
+			return
+		}
+		queue = Order_Array{order}
+	}
+
+	// the estimate always looks at the whole queue, so that
+	// orders without stats can borrow the others' times
+	all, _   := load_orders(config.project_dir, false)
+	estimate := estimate_queue(config, all)
+
+	if args.output != OUTPUT_TEXT {
+		write_data(args.output, export_stats(config, queue, estimate))
+		return
+	}
+
+	everything := make([]*Frame_Stat, 0, 256)
+	printed    := 0
+
+	printf("\n")
+
+	for _, order := range queue {
+		list := load_stats(config.project_dir, order.Name)
+		if len(list) == 0 && args.source_path == "" {
+			continue
+		}
+
+		everything = append(everything, list...)
+		printed++
+
+		print_stats(order, list, estimate)
+	}
+
+	if args.source_path != "" {
+		return
+	}
+
+	if printed == 0 {
+		printf("No frames have been rendered yet!\n")
+		return
+	}
+
+	printf("Machine Time\n")
+	for _, entry := range host_stats(everything) {
+		printf("   %-16s %-8s %d frames\n", truncate(entry.host, 16), format_seconds(entry.seconds), entry.frames)
+	}
+
+	if d, ok := estimate.eta(); ok {
+		printf("\nQueue ETA:       %s", format_duration(d))
+		if estimate.hosts > 1 {
+			printf(" across %d machines", estimate.hosts)
+		}
+		printf("\n")
+	}
+
+	printf("\n")
+}
+
+func print_stats(order *Order, list []*Frame_Stat, estimate *Queue_Estimate) {
+	printf(apply_color("[$1%s$0] %s\n"), order.Name, filepath.Base(order.Source_Path))
+
+	slowest := slowest_stats(list)
+
+	printf("   Rendered:     %d/%d frames\n", len(slowest), len(order.frame_list()))
+
+	if len(slowest) == 0 {
+		printf("\n")
+		return
+	}
+
+	times := make([]float64, len(slowest))
+	for i, stat := range slowest {
+		times[i] = stat.Seconds
+	}
+	sort.Float64s(times)
+
+	fastest := slowest[len(slowest) - 1]
+
+	printf("   Frame Time:   %s min (%d), %s median, %s max (%d)\n",
+		format_seconds(fastest.Seconds), fastest.Frame,
+		format_seconds(median(times)),
+		format_seconds(slowest[0].Seconds), slowest[0].Frame,
+	)
+
+	{
+		count := len(slowest)
+		if count > SLOWEST_FRAMES {
+			count = SLOWEST_FRAMES
+		}
+
+		parts := make([]string, count)
+		for i, stat := range slowest[:count] {
+			parts[i] = fmt.Sprintf("%d (%s)", stat.Frame, format_seconds(stat.Seconds))
+		}
+
+		printf("   Slowest:      %s\n", strings.Join(parts, ", "))
+	}
+
+	{
+		var peak *Frame_Stat
+		samples := uint(0)
+
+		for _, stat := range slowest {
+			if stat.Peak_Memory > 0 && (peak == nil || stat.Peak_Memory > peak.Peak_Memory) {
+				peak = stat
+			}
+			if stat.Sample_Total > samples {
+				samples = stat.Sample_Total
+			}
+		}
+
+		if peak != nil {
+			printf("   Peak Memory:  %s (%d)\n", format_memory(peak.Peak_Memory), peak.Frame)
+		}
+		if samples > 0 {
+			printf("   Samples:      %d\n", samples)
+		}
+	}
+
+	if progress, ok := estimate.progress[order.Name]; ok {
+		if d, ok := progress.eta(); ok {
+			printf("   ETA:          %s\n", format_duration(d))
+		}
+	}
+
+	for i, entry := range host_stats(list) {
+		label := "Machine Time:"
+		if i > 0 {
+			label = ""
+		}
+		printf("   %-13s %-16s %-8s %d frames\n", label, truncate(entry.host, 16), format_seconds(entry.seconds), entry.frames)
+	}
+
+	printf("\n")
+}
@@ -0,0 +1,250 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "fmt"
+import "time"
+import "strings"
+import "path/filepath"
+
+const STATUS_INTERVAL = 2 * time.Second
+
+// everything status needs to know about an order,
+// pieced together from its manifest, chunks and the
+// locks kept up to date by the rendering machines
+type Order_Progress struct {
+	Done    int
+	Total   int
+	Hosts   []string
+	Frames  []int
+	Started time.Time
+	rate    float64 // frames per second, across all hosts
+
+	frame_time float64 // typical seconds per frame, from the stats
+}
+
+func order_progress(config *Config, order *Order) *Order_Progress {
+	progress := &Order_Progress{
+		Total: len(order.render_list()),
+	}
+
+	if order.Status == STATUS_COMPLETE {
+		progress.Done = progress.Total
+		return progress
+	}
+
+	progress.frame_time = typical_frame_time(load_stats(config.project_dir, order.Name))
+
+	if order.Chunk_Size > 0 {
+		for _, chunk := range load_chunks(config.project_dir, order) {
+			if chunk.Complete {
+				progress.Done += len(order.frames_between(chunk.Start_Frame, chunk.End_Frame))
+				continue
+			}
+
+			if chunk.Has_Progress {
+				progress.Done += len(order.frames_between(chunk.Start_Frame, chunk.Last_Frame))
+			}
+
+			progress.add_lock(config, chunk.lock)
+		}
+		return progress
+	}
+
+	if order.Has_Progress {
+		progress.Done = len(order.frames_between(order.Start_Frame, order.Last_Frame))
+	}
+
+	progress.add_lock(config, order.lock)
+	return progress
+}
+
+func (progress *Order_Progress) add_lock(config *Config, lock *Lock) {
+	if lock == nil || lock.is_stale(config) {
+		return
+	}
+
+	progress.Hosts = append(progress.Hosts, lock.Host)
+
+	if !lock.Run_Started.IsZero() {
+		progress.Frames = append(progress.Frames, lock.Frame)
+	}
+
+	if progress.Started.IsZero() || lock.Started.Before(progress.Started) {
+		progress.Started = lock.Started
+	}
+
+	if lock.Done > 0 {
+		if elapsed := lock.Heartbeat.Sub(lock.Run_Started).Seconds(); elapsed > 0 {
+			progress.rate += float64(lock.Done) / elapsed
+		}
+	}
+}
+
+func (progress *Order_Progress) percentage() int {
+	if progress.Total == 0 {
+		return 0
+	}
+	return progress.Done * 100 / progress.Total
+}
+
+// based on how fast the machines currently working on
+// the order are getting through it, or on how long its
+// frames have taken so far if nobody is right now
+func (progress *Order_Progress) eta() (time.Duration, bool) {
+	remaining := float64(progress.Total - progress.Done)
+
+	if progress.rate > 0 {
+		return time.Duration(remaining / progress.rate * float64(time.Second)), true
+	}
+
+	if progress.frame_time <= 0 {
+		return 0, false
+	}
+
+	hosts := len(progress.Hosts)
+	if hosts == 0 {
+		hosts = 1
+	}
+
+	return time.Duration(progress.work() / float64(hosts) * float64(time.Second)), true
+}
+
+// seconds of rendering left for one machine
+func (progress *Order_Progress) work() float64 {
+	return float64(progress.Total - progress.Done) * progress.frame_time
+}
+
+func command_status(config *Config, args *Arguments) {
+	// the machine-readable status is just the queue,
+	// which already carries progress and lock holders
+	if args.output != OUTPUT_TEXT {
+		queue, ok := load_orders(config.project_dir, false)
+		if ok {
+			write_data(args.output, export_queue(config, queue))
+		}
+		return
+	}
+
+	if args.watch && running_in_term {
+		printf(CLEAR_SCREEN)
+	}
+
+	for {
+		buffer := strings.Builder{}
+		if !write_status(config, &buffer) {
+			return
+		}
+
+		if !args.watch {
+			printf("%s", buffer.String())
+			return
+		}
+
+		// piped into a file or another program, each
+		// table just follows on from the last
+		if !running_in_term {
+			printf("%s\n", buffer.String())
+			time.Sleep(STATUS_INTERVAL)
+			continue
+		}
+
+		// redrawing over the top of the last table and then
+		// clearing what's left is much less flickery than
+		// clearing the whole screen first
+		printf("%s", CURSOR_HOME + strings.ReplaceAll(buffer.String(), "\n", CLEAR_LINE_END + "\n") + CLEAR_SCREEN_END)
+
+		time.Sleep(STATUS_INTERVAL)
+	}
+}
+
+func write_status(config *Config, buffer *strings.Builder) bool {
+	queue, ok := load_orders(config.project_dir, false)
+	if !ok {
+		return false
+	}
+
+	if len(queue) == 0 {
+		buffer.WriteString("No orders found!\n")
+		return true
+	}
+
+	buffer.WriteString(fmt.Sprintf("%-12s %-20s %-11s %-16s %-8s %4s  %-8s %s\n", "Order", "File", "State", "Host", "Frame", "%", "Elapsed", "ETA"))
+
+	rendering := 0
+
+	for _, order := range queue {
+		progress := order_progress(config, order)
+		state    := order_state(config, order, queue)
+
+		host    := "-"
+		frame   := "-"
+		elapsed := "-"
+		eta     := "-"
+
+		if len(progress.Hosts) > 0 {
+			rendering++
+			host = strings.Join(progress.Hosts, ",")
+
+			if !progress.Started.IsZero() {
+				elapsed = format_duration(time.Since(progress.Started))
+			}
+		}
+
+		if len(progress.Frames) > 0 {
+			list := make([]string, len(progress.Frames))
+			for i, f := range progress.Frames {
+				list[i] = fmt.Sprint(f)
+			}
+			frame = strings.Join(list, ",")
+		}
+
+		if d, ok := progress.eta(); ok {
+			eta = format_duration(d)
+		}
+
+		line := fmt.Sprintf("%-12s %-20s %-11s %-16s %-8s %3d%%  %-8s %s\n",
+			truncate(order.Name, 12),
+			truncate(filepath.Base(order.Source_Path), 20),
+			state,
+			truncate(host, 16),
+			truncate(frame, 8),
+			progress.percentage(),
+			elapsed,
+			eta,
+		)
+
+		if order.Status == STATUS_FAILED || state == "blocked" || state == "interrupted" {
+			line = apply_color("$1" + line + "$0")
+		}
+
+		buffer.WriteString(line)
+	}
+
+	buffer.WriteString(fmt.Sprintf("\n%d orders, %d rendering, updated %s\n", len(queue), rendering, time.Now().Format("15:04:05")))
+	return true
+}
+
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length - 1]) + "…"
+}
@@ -19,7 +19,9 @@
 package main
 
 import "os"
+import "io"
 import "fmt"
+import "time"
 import "io/fs"
 import "errors"
 import "strings"
@@ -40,6 +42,30 @@ func lock_path(project_dir, name string) string {
 	return filepath.Join(project_dir, ORDER_DIR, name, LOCK_NAME)
 }
 
+func log_dir(project_dir, name string) string {
+	return filepath.Join(project_dir, ORDER_DIR, name, LOG_DIR)
+}
+
+func stats_dir(project_dir, name string) string {
+	return filepath.Join(project_dir, ORDER_DIR, name, STATS_DIR)
+}
+
+func chunk_dir(project_dir, name string) string {
+	return filepath.Join(project_dir, ORDER_DIR, name, CHUNK_DIR)
+}
+
+func chunk_manifest_path(project_dir, name string, index int) string {
+	return filepath.Join(project_dir, ORDER_DIR, name, CHUNK_DIR, fmt.Sprintf("%04d.toml", index + 1))
+}
+
+func chunk_lock_path(project_dir, name string, index int) string {
+	return filepath.Join(project_dir, ORDER_DIR, name, CHUNK_DIR, fmt.Sprintf("%04d.lock", index + 1))
+}
+
+func complete_lock_path(project_dir, name string) string {
+	return filepath.Join(project_dir, ORDER_DIR, name, CHUNK_DIR, COMPLETE_LOCK)
+}
+
 func file_exists(path string) bool {
 	_, err := os.Stat(path)
 	return !errors.Is(err, os.ErrNotExist)
@@ -115,6 +141,31 @@ func parse_uint(str string) (uint, bool) {
 	return uint(u), true
 }
 
+func format_duration(d time.Duration) string {
+	d = d.Round(time.Second)
+
+	h := int(d.Hours())
+	m := int(d.Minutes()) % 60
+	s := int(d.Seconds()) % 60
+
+	if h > 0 {
+		return fmt.Sprintf("%dh%02dm", h, m)
+	}
+	if m > 0 {
+		return fmt.Sprintf("%dm%02ds", m, s)
+	}
+	return fmt.Sprintf("%ds", s)
+}
+
+func parse_int(str string) (int, bool) {
+	i, err := strconv.ParseInt(str, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+
+	return int(i), true
+}
+
 var running_in_term = false
 
 func init() {
@@ -156,19 +207,23 @@ func printf(format string, guff ...any) {
 	}
 }*/
 
+// errors normally go to stderr, but serve collects
+// them so they can be sent back to the client
+var error_output io.Writer = os.Stderr
+
 func eprintln(words ...string) {
 	l := len(words) - 1
 	for i, w := range words {
-		os.Stderr.WriteString(w)
+		io.WriteString(error_output, w)
 		if i < l {
-			os.Stderr.WriteString(" ")
+			io.WriteString(error_output, " ")
 		}
 	}
-	os.Stderr.WriteString("\n")
+	io.WriteString(error_output, "\n")
 }
 
 func eprintf(format string, guff ...any) {
-	fmt.Fprintf(os.Stderr, format, guff...)
+	fmt.Fprintf(error_output, format, guff...)
 }
 
 func hostname() string {
@@ -184,6 +239,11 @@ const ANSI_RESET = "\033[0m"
 const ANSI_COLOR = "\033[91m"
 const RESET_LINE = "\033[2K\r"
 
+const CLEAR_SCREEN     = "\033[2J"
+const CURSOR_HOME      = "\033[H"
+const CLEAR_LINE_END   = "\033[K"
+const CLEAR_SCREEN_END = "\033[J"
+
 func apply_color(input string) string {
 	buffer := strings.Builder{}
 	buffer.Grow(len(input) + 128)
@@ -228,3 +288,13 @@ func apply_color(input string) string {
 
 	return buffer.String()
 }
+
+// for anything that waits on Blender or BAT, during
+// which nothing may be printed as an error
+func (config *Config) outside_lock(fn func()) {
+	if config.unlocked == nil {
+		fn()
+		return
+	}
+	config.unlocked(fn)
+}
@@ -0,0 +1,334 @@
+/*
+	Sous Chef
+	Copyright (C) 2022-2023 Harley Denham
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import "os"
+import "fmt"
+import "bufio"
+import "strings"
+import "os/exec"
+import "image/png"
+import "image/jpeg"
+import "path/filepath"
+
+const (
+	FRAME_OK uint8 = iota
+	FRAME_MISSING
+	FRAME_EMPTY
+	FRAME_BROKEN
+)
+
+// one output pattern of an order, either the scene's
+// own output path or a File Output node slot
+type Output_Check struct {
+	Pattern string
+	Files   []string
+	Missing []int
+	Empty   []int
+	Broken  []int
+}
+
+// Blender works out every output filename itself, after
+// the same path rewriting used for rendering, so that we
+// don't have to reimplement its frame and extension rules.
+// File Output nodes don't have an equivalent of frame_path,
+// so those are expanded by hand
+const VERIFY_EXPRESSION = `
+import re
+from os.path import join
+
+EXTENSIONS = {
+    'BMP': '.bmp', 'IRIS': '.rgb', 'PNG': '.png', 'JPEG': '.jpg',
+    'JPEG2000': '.jp2', 'TARGA': '.tga', 'TARGA_RAW': '.tga',
+    'CINEON': '.cin', 'DPX': '.dpx', 'OPEN_EXR': '.exr',
+    'OPEN_EXR_MULTILAYER': '.exr', 'HDR': '.hdr', 'TIFF': '.tif',
+    'WEBP': '.webp',
+}
+
+def frame_file(path, frame, ext):
+    path = bpy.path.abspath(path)
+    runs = list(re.finditer("#+", path))
+    if runs:
+        run = runs[-1]
+        path = path[:run.start()] + "%0*d" % (len(run.group()), frame) + path[run.end():]
+    else:
+        path += "%04d" % frame
+    if not path.lower().endswith(ext):
+        path += ext
+    return path
+
+scene = bpy.context.scene
+
+print("sous_output\t0\t" + bpy.path.abspath(scene.render.filepath))
+for f in frames:
+    print("sous_file\t0\t%d\t%s" % (f, scene.render.frame_path(frame=f)))
+
+index = 1
+if scene.use_nodes and scene.node_tree:
+    for node in scene.node_tree.nodes:
+        if node.type != 'OUTPUT_FILE' or node.mute or "ignore" in node.label.lower():
+            continue
+        if node.format.file_format == 'OPEN_EXR_MULTILAYER':
+            paths = [(node.base_path, node.format.file_format)]
+        else:
+            paths = []
+            for slot in node.file_slots:
+                format = node.format if slot.use_node_format else slot.format
+                paths.append((join(node.base_path, slot.path), format.file_format))
+        for path, format in paths:
+            print("sous_output\t%d\t%s" % (index, bpy.path.abspath(path)))
+            for f in frames:
+                print("sous_file\t%d\t%d\t%s" % (index, f, frame_file(path, f, EXTENSIONS.get(format, ""))))
+            index += 1
+`
+
+func command_verify(config *Config, args *Arguments) {
+	queue, ok := load_orders(config.project_dir, false)
+	if !ok {
+		return
+	}
+
+	order, ok := find_order(queue, args.source_path)
+	if !ok {
+		eprintf(apply_color("Order $1%q$0 does not exist\n"), args.source_path)
+		return
+	}
+
+	basename := filepath.Base(order.Source_Path)
+
+	if args.output != OUTPUT_TEXT {
+		outputs, ok := verify_outputs(config, order)
+		if !ok {
+			eprintf("Failed to gather outputs from %s!\n", basename)
+			return
+		}
+
+		data := &Export_Verify{
+			Schema_Version: SCHEMA_VERSION,
+			Order:          order.Name,
+			Outputs:        make([]*Export_Output, 0, len(outputs)),
+		}
+
+		for _, output := range outputs {
+			data.Outputs = append(data.Outputs, &Export_Output{
+				Pattern: output.Pattern,
+				Missing: non_nil(output.Missing),
+				Empty:   non_nil(output.Empty),
+				Broken:  non_nil(output.Broken),
+			})
+		}
+
+		// the redo happens first, so the data reflects
+		// whether the frames really were requeued
+		data.Requeued = []int{}
+
+		if frames := bad_frames(order, outputs); len(frames) > 0 && args.verify_redo {
+			if redo_order(config, order, format_frames(frames, "-")) {
+				data.Requeued = frames
+			}
+		}
+
+		write_data(args.output, data)
+		return
+	}
+
+	printf("Gathering outputs from %s...", basename)
+
+	outputs, ok := verify_outputs(config, order)
+	if !ok {
+		eprintf("Failed to gather outputs from %s!\n", basename)
+		return
+	}
+
+	printf(RESET_LINE)
+	printf(apply_color("[$1%s$0] %s | %d frames in %d outputs\n"), order.Name, basename, len(order.frame_list()), len(outputs))
+
+	for _, output := range outputs {
+		pattern := output.Pattern
+		if rel, err := filepath.Rel(config.project_dir, pattern); err == nil && !strings.HasPrefix(rel, "..") {
+			pattern = filepath.ToSlash(rel)
+		}
+
+		if len(output.Missing) + len(output.Empty) + len(output.Broken) == 0 {
+			printf("   %s ✓\n", pattern)
+			continue
+		}
+
+		printf(apply_color("   $1%s$0\n"), pattern)
+
+		if len(output.Missing) > 0 {
+			printf("      Missing:  %s\n", format_frames(output.Missing, "-"))
+		}
+		if len(output.Empty) > 0 {
+			printf("      Empty:    %s\n", format_frames(output.Empty, "-"))
+		}
+		if len(output.Broken) > 0 {
+			printf("      Broken:   %s\n", format_frames(output.Broken, "-"))
+		}
+	}
+
+	frames := bad_frames(order, outputs)
+
+	if len(frames) == 0 || !args.verify_redo {
+		return
+	}
+
+	if redo_order(config, order, format_frames(frames, "-")) {
+		printf(apply_color("[$1%s$0] requeued %d frames\n"), order.Name, len(frames))
+	}
+}
+
+// every frame with a problem in any output, in order
+func bad_frames(order *Order, outputs []*Output_Check) []int {
+	bad := make(map[int]bool, 16)
+
+	for _, output := range outputs {
+		for _, list := range [][]int{output.Missing, output.Empty, output.Broken} {
+			for _, frame := range list {
+				bad[frame] = true
+			}
+		}
+	}
+
+	frames := make([]int, 0, len(bad))
+	for _, frame := range order.frame_list() {
+		if bad[frame] {
+			frames = append(frames, frame)
+		}
+	}
+
+	return frames
+}
+
+// asks Blender for every file the order should have
+// written and checks each of them on disk
+func verify_outputs(config *Config, order *Order) ([]*Output_Check, bool) {
+	blender_path, ok := get_blender_path(config, order.Blender_Target)
+	if !ok {
+		return nil, false
+	}
+
+	frames := order.frame_list()
+	if len(frames) == 0 {
+		return nil, false
+	}
+
+	list := make([]string, len(frames))
+	for i, frame := range frames {
+		list[i] = fmt.Sprint(frame)
+	}
+
+	expression := inject(config.project_dir, order, frames[0], frames[len(frames) - 1])
+	expression += "frames = [" + strings.Join(list, ",") + "]\n"
+	expression += VERIFY_EXPRESSION
+
+	arguments := []string{"-b", filepath.Join(config.project_dir, order.Target_Path)}
+	if order.Scene != "" {
+		arguments = append(arguments, "-S", order.Scene)
+	}
+	arguments = append(arguments, "--python-expr", expression)
+
+	cmd := exec.Command(blender_path, arguments...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, false
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, false
+	}
+
+	outputs := make([]*Output_Check, 0, 4)
+
+	scanner := bufio.NewScanner(stdout)
+
+	for scanner.Scan() {
+		part := strings.Split(scanner.Text(), "\t")
+
+		switch part[0] {
+		case "sous_output":
+			if len(part) == 3 {
+				outputs = append(outputs, &Output_Check{Pattern: part[2]})
+			}
+
+		case "sous_file":
+			if len(part) != 4 || len(outputs) == 0 {
+				continue
+			}
+
+			frame, ok := parse_int(part[2])
+			if !ok {
+				continue
+			}
+
+			output := outputs[len(outputs) - 1]
+			output.Files = append(output.Files, part[3])
+
+			switch check_frame_file(part[3]) {
+			case FRAME_MISSING:
+				output.Missing = append(output.Missing, frame)
+			case FRAME_EMPTY:
+				output.Empty = append(output.Empty, frame)
+			case FRAME_BROKEN:
+				output.Broken = append(output.Broken, frame)
+			}
+		}
+	}
+
+	cmd.Wait()
+
+	return outputs, len(outputs) > 0
+}
+
+// only PNG and JPEG can be decoded without extra
+// dependencies, so everything else just has to exist
+// and have something in it
+func check_frame_file(path string) uint8 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return FRAME_MISSING
+	}
+
+	if info.Size() == 0 {
+		return FRAME_EMPTY
+	}
+
+	var decode func(*os.File) error
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		decode = func(f *os.File) error { _, err := png.Decode(f); return err }
+	case ".jpg", ".jpeg":
+		decode = func(f *os.File) error { _, err := jpeg.Decode(f); return err }
+	default:
+		return FRAME_OK
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return FRAME_BROKEN
+	}
+	defer f.Close()
+
+	if decode(f) != nil {
+		return FRAME_BROKEN
+	}
+	return FRAME_OK
+}